@@ -0,0 +1,37 @@
+// Command migrate runs the schema migrations against a database of the config.
+//
+//	migrate -config config.toml -db users -dir migrations up|down|to <version>|status
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/transerver/commons/configs"
+	"github.com/transerver/commons/dbs"
+	"os"
+)
+
+func main() {
+	configFile := flag.String("config", "config.toml", "the config file with the databases")
+	dbName := flag.String("db", "", "the DBName of the database to migrate")
+	dir := flag.String("dir", "migrations", "the directory of the migration files")
+	flag.Parse()
+
+	if *dbName == "" || flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: migrate -config config.toml -db <dbName> -dir migrations up|down|to <version>|status")
+		os.Exit(2)
+	}
+
+	configs.SetConfigFile(*configFile)
+	if err := configs.ReadConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "read config fail: %v\n", err)
+		os.Exit(1)
+	}
+
+	err := dbs.RunMigrateCommand(context.Background(), os.Stdout, *dbName, os.DirFS(*dir), flag.Args()...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate fail: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -14,15 +14,31 @@ type DBConfig struct {
 
 	DesensitiseDSN string `json:"desensitiseDsn,omitempty" toml:"desensitiseDsn" yaml:"desensitiseDsn"`
 
-	URL     string `json:"url,omitempty" toml:"url" yaml:"url"`
+	URL string `json:"url,omitempty" toml:"url" yaml:"url"`
+
+	// ReplicaURLs the urls of the read replicas, the driver must be the same as the URL
+	ReplicaURLs []string `json:"replicaUrls,omitempty" toml:"replicaUrls" yaml:"replicaUrls"`
+	// Replicas parsed from the ReplicaURLs
+	Replicas []*ReplicaConfig `json:"-" toml:"-" yaml:"-"`
+
 	Options struct {
 		MaxOpenConns    int           `json:"maxOpenConns,omitempty" toml:"maxOpenConns" yaml:"maxOpenConns"`
 		MaxIdleConns    int           `json:"maxIdleConns,omitempty" toml:"maxIdleConns" yaml:"maxIdleConns"`
 		ConnMaxIdleTime time.Duration `json:"connMaxIdleTime,omitempty" toml:"connMaxIdleTime" yaml:"connMaxIdleTime"`
 		ConnMaxLifetime time.Duration `json:"connMaxLifetime,omitempty" toml:"connMaxLifeTime" yaml:"connMaxLifeTime"`
+
+		// ReplicaStrategy how to pick a replica for the reads, "roundRobin" (default) or "leastLatency"
+		ReplicaStrategy string `json:"replicaStrategy,omitempty" toml:"replicaStrategy" yaml:"replicaStrategy"`
+		// ReplicaPingInterval how often the replicas are pinged, the unhealthy ones are out of rotation
+		ReplicaPingInterval time.Duration `json:"replicaPingInterval,omitempty" toml:"replicaPingInterval" yaml:"replicaPingInterval"`
 	} `json:"options,omitempty" toml:"options" yaml:"options"`
 }
 
+type ReplicaConfig struct {
+	DSN            string
+	DesensitiseDSN string
+}
+
 type dbFetcher struct {
 	instance []*DBConfig
 	mutex    sync.Mutex
@@ -50,6 +66,20 @@ func dbHandler(value interface{}) {
 		config.DSN = url.DSN
 		config.Driver = url.Driver
 
+		config.Replicas = config.Replicas[:0]
+		for _, replicaURL := range config.ReplicaURLs {
+			replica, _, replicaDSN, err := ParseURL(replicaURL)
+			if err != nil {
+				logger.Errorf("parse replica url of [%s] fail: %+v", dbName, err)
+				continue
+			}
+			if replica.Driver != config.Driver {
+				logger.Errorf("the driver of replica [%s] isn't %s, will be ignore.", replicaDSN, config.Driver)
+				continue
+			}
+			config.Replicas = append(config.Replicas, &ReplicaConfig{DSN: replica.DSN, DesensitiseDSN: replicaDSN})
+		}
+
 		l := len(names)
 		names[dbName] = struct{}{}
 		if len(names) == l {
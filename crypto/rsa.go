@@ -2,6 +2,7 @@ package crypto
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -63,7 +64,11 @@ func (o *RsaObj) Decrypt(ciphertext []byte) ([]byte, error) {
 }
 
 func (o *RsaObj) Release() error {
-	return redis.Client().Del(o.requestId).Err()
+	return o.ReleaseCtx(context.Background())
+}
+
+func (o *RsaObj) ReleaseCtx(ctx context.Context) error {
+	return redis.Client().Del(ctx, o.requestId).Err()
 }
 
 type globalOption struct {
@@ -122,8 +127,13 @@ func RsaKeyPrefix() string {
 	return global.prefix
 }
 
-// FetchRsaKey called globalOption.fetch
+// FetchRsaKey called FetchRsaKeyCtx with context.Background
 func FetchRsaKey(requestId string, opts ...Option) (*RsaObj, error) {
+	return FetchRsaKeyCtx(context.Background(), requestId, opts...)
+}
+
+// FetchRsaKeyCtx called globalOption.fetch
+func FetchRsaKeyCtx(ctx context.Context, requestId string, opts ...Option) (*RsaObj, error) {
 	if len(requestId) == 0 {
 		return nil, errors.New("empty requestId for fetch rsa key")
 	}
@@ -133,7 +143,7 @@ func FetchRsaKey(requestId string, opts ...Option) (*RsaObj, error) {
 		opt(g)
 	}
 	g.init()
-	return g.fetch()
+	return g.fetch(ctx)
 }
 
 func (g *generator) init() {
@@ -150,15 +160,15 @@ func (g *generator) init() {
 }
 
 // fetch first pull from redis, if is not exist, then create and saved to redis
-func (g *generator) fetch() (*RsaObj, error) {
-	cmd := redis.Client().Get(g.requestId)
+func (g *generator) fetch(ctx context.Context) (*RsaObj, error) {
+	cmd := redis.Client().Get(ctx, g.requestId)
 	var rsaObj RsaObj
 	if cmd.Err() == redis.Nil {
 		if !g.renew {
 			return nil, RsaKeyNotExist{g.requestId}
 		}
 
-		key, err := g.createRsaKey()
+		key, err := g.createRsaKey(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -176,12 +186,12 @@ func (g *generator) fetch() (*RsaObj, error) {
 }
 
 // createRsaKey only generate rsa key and saved to redis
-func (g *generator) createRsaKey() (*RsaObj, error) {
+func (g *generator) createRsaKey(ctx context.Context) (*RsaObj, error) {
 	key, err := g.genRsaKey()
 	if err != nil {
 		return nil, err
 	}
-	status, err := redis.Client().Set(g.requestId, key, g.expiration).Result()
+	status, err := redis.Client().Set(ctx, g.requestId, key, g.expiration).Result()
 	if err != nil {
 		return nil, err
 	}
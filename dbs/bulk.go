@@ -0,0 +1,317 @@
+package dbs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx/reflectx"
+	"github.com/lib/pq"
+	"reflect"
+	"strings"
+)
+
+// maxBulkParams the max number of the parameters of a statement, it's the same for Postgres and MySQL
+const maxBulkParams = 65535
+
+var (
+	ErrInvalidBulkRows = errors.New("dbs: the rows must be a slice of structs or pointers to structs")
+	ErrBulkNoColumns   = errors.New("dbs: no columns to insert")
+	ErrCopyUnsupported = errors.New("dbs: COPY FROM is only supported by postgres")
+	ErrBulkNoConflict  = errors.New("dbs: the conflict columns are required by the upsert of postgres")
+)
+
+type bulkOptions struct {
+	columns   []string
+	conflict  []string
+	updates   []string
+	doNothing bool
+	upsert    bool
+	batchSize int
+}
+
+type BulkOption func(o *bulkOptions)
+
+// WithBulkColumns settings the columns to insert, default is all the fields with the db tags
+func WithBulkColumns(columns ...string) BulkOption {
+	return func(o *bulkOptions) {
+		o.columns = columns
+	}
+}
+
+// WithUpdateColumns settings the columns updated on conflict, default is all the columns except the conflict ones
+func WithUpdateColumns(columns ...string) BulkOption {
+	return func(o *bulkOptions) {
+		o.updates = columns
+	}
+}
+
+// WithDoNothing ignores the conflicted rows instead of updating them, it's ON CONFLICT DO NOTHING on Postgres
+// and ON DUPLICATE KEY UPDATE of a column to itself on MySQL, because INSERT IGNORE also turns
+// the truncations and the invalid values into warnings
+func WithDoNothing() BulkOption {
+	return func(o *bulkOptions) {
+		o.doNothing = true
+	}
+}
+
+// WithBatchSize settings the max number of rows of a statement, the rows are also chunked
+// by the parameter limit of the database
+func WithBatchSize(rows int) BulkOption {
+	return func(o *bulkOptions) {
+		o.batchSize = rows
+	}
+}
+
+// BulkInsert inserts the rows by the multi-row INSERT statements, the rows is a slice of structs
+// or pointers to structs, and the columns are the fields with the db tags. The rows are chunked
+// under the parameter limit, and all the chunks are inserted in a transaction, or in the transaction of ctx.
+// Returns the number of rows affected. The table and the columns are used in the statements as is.
+func (db *Database) BulkInsert(ctx context.Context, table string, rows interface{}, opts ...BulkOption) (int64, error) {
+	o := &bulkOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return db.bulkInsert(ctx, table, rows, o)
+}
+
+// BulkUpsert inserts the rows as the BulkInsert, the rows conflicted on the conflict columns are updated,
+// it's ON CONFLICT (conflict) DO UPDATE on Postgres and ON DUPLICATE KEY UPDATE on MySQL,
+// the conflict is decided by the unique keys of the table on MySQL. On Postgres, the rows of a chunk
+// shouldn't conflict with each other, and the rows affected of MySQL count 2 for each updated row.
+func (db *Database) BulkUpsert(ctx context.Context, table string, rows interface{}, conflict []string, opts ...BulkOption) (int64, error) {
+	o := &bulkOptions{conflict: conflict, upsert: true}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if len(o.conflict) == 0 && !o.doNothing && db.DriverName() != "mysql" {
+		return 0, ErrBulkNoConflict
+	}
+	return db.bulkInsert(ctx, table, rows, o)
+}
+
+func (db *Database) bulkInsert(ctx context.Context, table string, rows interface{}, o *bulkOptions) (int64, error) {
+	values, columns, err := db.bulkRows(rows, o.columns)
+	if err != nil || len(values) == 0 {
+		return 0, err
+	}
+
+	size := maxBulkParams / len(columns)
+	if o.batchSize > 0 && o.batchSize < size {
+		size = o.batchSize
+	}
+
+	var affected int64
+	err = db.InTx(ctx, nil, func(tx *Tx) error {
+		affected = 0
+		for start := 0; start < len(values); start += size {
+			end := start + size
+			if end > len(values) {
+				end = len(values)
+			}
+
+			query, args := db.bulkInsertSQL(table, columns, values[start:end], o)
+			result, err := tx.ExecContext(tx.Context(), query, args...)
+			if err != nil {
+				return err
+			}
+			if n, err := result.RowsAffected(); err == nil {
+				affected += n
+			}
+		}
+		return nil
+	})
+	return affected, err
+}
+
+func (db *Database) bulkInsertSQL(table string, columns []string, rows [][]interface{}, o *bulkOptions) (string, []interface{}) {
+	mysql := db.DriverName() == "mysql"
+	updates := o.updates
+	if o.upsert && len(updates) == 0 {
+		updates = excludeColumns(columns, o.conflict)
+	}
+	doNothing := o.doNothing || o.upsert && len(updates) == 0
+
+	var b strings.Builder
+	b.WriteString("INSERT INTO " + table + " (" + strings.Join(columns, ", ") + ") VALUES ")
+
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	args := make([]interface{}, 0, len(rows)*len(columns))
+	for i, row := range rows {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(placeholders)
+		args = append(args, row...)
+	}
+
+	switch {
+	case doNothing && !mysql:
+		b.WriteString(" ON CONFLICT")
+		if len(o.conflict) > 0 {
+			b.WriteString(" (" + strings.Join(o.conflict, ", ") + ")")
+		}
+		b.WriteString(" DO NOTHING")
+	case doNothing && mysql:
+		column := columns[0]
+		if len(o.conflict) > 0 {
+			column = o.conflict[0]
+		}
+		b.WriteString(" ON DUPLICATE KEY UPDATE " + column + " = " + column)
+	case !o.upsert:
+	case mysql:
+		b.WriteString(" ON DUPLICATE KEY UPDATE ")
+		for i, column := range updates {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(column + " = VALUES(" + column + ")")
+		}
+	default:
+		b.WriteString(" ON CONFLICT (" + strings.Join(o.conflict, ", ") + ") DO UPDATE SET ")
+		for i, column := range updates {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(column + " = EXCLUDED." + column)
+		}
+	}
+	return db.Rebind(b.String()), args
+}
+
+// CopyFrom streams the rows to the table by COPY FROM STDIN of Postgres, it's faster than
+// the BulkInsert for lots of rows, but it doesn't support the conflicts. The rows are copied in a
+// transaction, or in the transaction of ctx. The table may be qualified by the schema.
+func (db *Database) CopyFrom(ctx context.Context, table string, rows interface{}, opts ...BulkOption) (int64, error) {
+	if db.DriverName() != "postgres" {
+		return 0, ErrCopyUnsupported
+	}
+
+	o := &bulkOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	values, columns, err := db.bulkRows(rows, o.columns)
+	if err != nil || len(values) == 0 {
+		return 0, err
+	}
+
+	query := pq.CopyIn(table, columns...)
+	if i := strings.IndexByte(table, '.'); i >= 0 {
+		query = pq.CopyInSchema(table[:i], table[i+1:], columns...)
+	}
+
+	var affected int64
+	err = db.InTx(ctx, nil, func(tx *Tx) error {
+		hctx := db.before(tx.Context(), query)
+		n, err := copyRows(hctx, tx, query, values)
+		affected = n
+		return db.after(hctx, err, query)
+	})
+	return affected, err
+}
+
+func copyRows(ctx context.Context, tx *Tx, query string, rows [][]interface{}) (int64, error) {
+	stmt, err := tx.tx.PrepareContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	for _, row := range rows {
+		if _, err = stmt.ExecContext(ctx, row...); err != nil {
+			return 0, err
+		}
+	}
+
+	// flush the buffered rows
+	result, err := stmt.ExecContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		return n, nil
+	}
+	return int64(len(rows)), nil
+}
+
+// bulkRows returns the values of the columns of each row, the columns are all the fields with the db tags if it's empty
+func (db *Database) bulkRows(rows interface{}, columns []string) ([][]interface{}, []string, error) {
+	rv := reflect.ValueOf(rows)
+	if rv.Kind() != reflect.Slice {
+		return nil, nil, ErrInvalidBulkRows
+	}
+	t := reflectx.Deref(rv.Type().Elem())
+	if t.Kind() != reflect.Struct {
+		return nil, nil, ErrInvalidBulkRows
+	}
+
+	tm := db.Mapper.TypeMap(t)
+	if len(columns) == 0 {
+		columns = structColumns(tm.Tree, nil)
+	}
+	if len(columns) == 0 {
+		return nil, nil, ErrBulkNoColumns
+	}
+
+	indexes := make([][]int, len(columns))
+	for i, column := range columns {
+		fi, ok := tm.Names[column]
+		if !ok {
+			return nil, nil, fmt.Errorf("dbs: missing column [%s] in %s", column, t)
+		}
+		indexes[i] = fi.Index
+	}
+
+	values := make([][]interface{}, rv.Len())
+	for i := range values {
+		v := rv.Index(i)
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return nil, nil, fmt.Errorf("dbs: the row %d is nil", i)
+			}
+			v = v.Elem()
+		}
+
+		row := make([]interface{}, len(columns))
+		for j, index := range indexes {
+			row[j] = reflectx.FieldByIndexesReadOnly(v, index).Interface()
+		}
+		values[i] = row
+	}
+	return values, columns, nil
+}
+
+// structColumns returns the columns of the fields with the db tags in the order of declaration,
+// the fields of the embedded structs without the db tags are promoted
+func structColumns(fi *reflectx.FieldInfo, columns []string) []string {
+	for _, child := range fi.Children {
+		if child == nil || child.Name == "" || child.Name == "-" {
+			continue
+		}
+		if child.Field.Tag.Get("db") == "" {
+			if child.Embedded {
+				columns = structColumns(child, columns)
+			}
+			continue
+		}
+		columns = append(columns, child.Path)
+	}
+	return columns
+}
+
+func excludeColumns(columns, excluded []string) []string {
+	var result []string
+	for _, column := range columns {
+		found := false
+		for _, e := range excluded {
+			if e == column {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, column)
+		}
+	}
+	return result
+}
@@ -0,0 +1,102 @@
+package dbs
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"testing"
+	"time"
+)
+
+type bulkBase struct {
+	ID int64 `db:"id"`
+}
+
+type bulkUser struct {
+	bulkBase
+	Name      string    `db:"name"`
+	Email     string    `db:"email"`
+	CreatedAt time.Time `db:"created_at"`
+	Ignored   string    `db:"-"`
+	Untagged  string
+	Profile   struct {
+		Bio string `db:"bio"`
+	}
+}
+
+func bulkUsers(n int) []*bulkUser {
+	users := make([]*bulkUser, n)
+	for i := range users {
+		users[i] = &bulkUser{bulkBase: bulkBase{ID: int64(i + 1)}, Name: "user", Email: "user@example.com"}
+	}
+	return users
+}
+
+func TestBulkInsert(t *testing.T) {
+	db, mock, _ := newMockDatabase(t)
+	users := bulkUsers(3)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO users (id, name, email, created_at) VALUES ($1, $2, $3, $4), ($5, $6, $7, $8)")).
+		WithArgs(int64(1), "user", "user@example.com", time.Time{}, int64(2), "user", "user@example.com", time.Time{}).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO users (id, name, email, created_at) VALUES ($1, $2, $3, $4)")).
+		WithArgs(int64(3), "user", "user@example.com", time.Time{}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	affected, err := db.BulkInsert(context.Background(), "users", users, WithBatchSize(2))
+	require.NoError(t, err)
+	require.Equal(t, int64(3), affected)
+
+	_, err = db.BulkInsert(context.Background(), "users", users[0])
+	require.ErrorIs(t, err, ErrInvalidBulkRows)
+	affected, err = db.BulkInsert(context.Background(), "users", []bulkUser{})
+	require.NoError(t, err)
+	require.Zero(t, affected)
+}
+
+func TestBulkInsertSQL(t *testing.T) {
+	db, _, _ := newMockDatabase(t)
+	values, columns, err := db.bulkRows(bulkUsers(1), []string{"id", "name", "email"})
+	require.NoError(t, err)
+
+	query, args := db.bulkInsertSQL("users", columns, values, &bulkOptions{conflict: []string{"id"}, upsert: true})
+	require.Equal(t, "INSERT INTO users (id, name, email) VALUES ($1, $2, $3) ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name, email = EXCLUDED.email", query)
+	require.Len(t, args, 3)
+
+	query, _ = db.bulkInsertSQL("users", columns, values, &bulkOptions{conflict: []string{"email"}, doNothing: true})
+	require.Equal(t, "INSERT INTO users (id, name, email) VALUES ($1, $2, $3) ON CONFLICT (email) DO NOTHING", query)
+
+	mysql := NewDatabase()
+	mysql.DB = sqlx.NewDb(db.DB.DB, "mysql")
+	query, _ = mysql.bulkInsertSQL("users", columns, values, &bulkOptions{conflict: []string{"id"}, updates: []string{"name"}, upsert: true})
+	require.Equal(t, "INSERT INTO users (id, name, email) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE name = VALUES(name)", query)
+
+	query, _ = mysql.bulkInsertSQL("users", columns, values, &bulkOptions{doNothing: true})
+	require.Equal(t, "INSERT INTO users (id, name, email) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE id = id", query)
+	query, _ = mysql.bulkInsertSQL("users", columns, values, &bulkOptions{conflict: []string{"email"}, doNothing: true})
+	require.Equal(t, "INSERT INTO users (id, name, email) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE email = email", query)
+
+	_, err = db.BulkUpsert(context.Background(), "users", bulkUsers(1), nil)
+	require.ErrorIs(t, err, ErrBulkNoConflict)
+}
+
+func TestCopyFrom(t *testing.T) {
+	db, mock, hook := newMockDatabase(t)
+
+	copyIn := regexp.QuoteMeta(`COPY "public"."users" ("id", "name") FROM STDIN`)
+	mock.ExpectBegin()
+	prepare := mock.ExpectPrepare(copyIn)
+	prepare.ExpectExec().WithArgs(int64(1), "user").WillReturnResult(sqlmock.NewResult(0, 0))
+	prepare.ExpectExec().WithArgs(int64(2), "user").WillReturnResult(sqlmock.NewResult(0, 0))
+	prepare.ExpectExec().WithArgs().WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	affected, err := db.CopyFrom(context.Background(), "public.users", bulkUsers(2), WithBulkColumns("id", "name"))
+	require.NoError(t, err)
+	require.Equal(t, int64(2), affected)
+	require.Equal(t, []string{"BEGIN", `COPY "public"."users" ("id", "name") FROM STDIN`, "COMMIT"}, hook.queries)
+}
@@ -13,6 +13,13 @@ import (
 	"github.com/transerver/commons/logger"
 	"strings"
 	"sync"
+	"time"
+)
+
+const (
+	defaultTxAttempts   = 3
+	defaultTxBackoff    = time.Millisecond * 20
+	defaultTxMaxBackoff = time.Second
 )
 
 var (
@@ -25,6 +32,17 @@ type Database struct {
 	config *configs.DBConfig
 	Logger *logger.Logger
 	Hook   DatabaseHook
+
+	hookErrorPolicy HookErrorPolicy
+
+	txAttempts   int
+	txBackoff    time.Duration
+	txMaxBackoff time.Duration
+
+	// replicas the read replicas, nil if no replicas configured
+	replicas *replicas
+	// stmts the prepared statements cache, nil if it's disabled
+	stmts *stmtCache
 }
 
 type Option func(db *Database)
@@ -35,9 +53,17 @@ func WithLogger(logger *logger.Logger) Option {
 	}
 }
 
-func WithHook(hook DatabaseHook) Option {
+// WithHook appends the hooks to the hooks of the Database, see AddHook
+func WithHook(hooks ...DatabaseHook) Option {
 	return func(db *Database) {
-		db.Hook = hook
+		db.AddHook(hooks...)
+	}
+}
+
+// WithHookErrorPolicy settings whether the errors of the hooks fail the statements, default is HookErrorReturn
+func WithHookErrorPolicy(policy HookErrorPolicy) Option {
+	return func(db *Database) {
+		db.hookErrorPolicy = policy
 	}
 }
 
@@ -47,8 +73,35 @@ func WithConfig(cfg *configs.DBConfig) Option {
 	}
 }
 
+// WithTxRetry settings how InTx retries the transactions failed with a retryable error,
+// the transaction is run attempts times at most, and the nth retry is delayed base*2^(n-1) up to max with jitter.
+// The retry is disabled if attempts is less than 2, default is 3 attempts from 20ms up to 1s.
+func WithTxRetry(attempts int, base, max time.Duration) Option {
+	return func(db *Database) {
+		db.txAttempts = attempts
+		db.txBackoff = base
+		db.txMaxBackoff = max
+	}
+}
+
+// WithStmtCache enables the cache of the prepared statements, at most size statements are cached
+// and the least recently used ones are closed. The statements in the transactions aren't cached.
+func WithStmtCache(size int) Option {
+	return func(db *Database) {
+		if size > 0 {
+			db.stmts = newStmtCache(size)
+		} else {
+			db.stmts = nil
+		}
+	}
+}
+
 func NewDatabase(opts ...Option) *Database {
-	db := &Database{}
+	db := &Database{
+		txAttempts:   defaultTxAttempts,
+		txBackoff:    defaultTxBackoff,
+		txMaxBackoff: defaultTxMaxBackoff,
+	}
 	db.getOpts(opts...)
 	return db
 }
@@ -79,10 +132,24 @@ func (db *Database) NamedExec(query string, arg interface{}) (sql.Result, error)
 	return db.Exec(querySQL, args...)
 }
 
+// SelectContext joins the transaction carried by ctx if any, it's the same for the other XxxContext methods.
+// Otherwise, the read-only SELECT and WITH statements of Select, Get and Query are routed to a healthy replica
+// if the replicas configured, the writes, e.g. INSERT ... RETURNING, the locking reads, e.g. SELECT ... FOR UPDATE,
+// and the transactions are always on the primary. Use the ctx returned by ForcePrimary to read the rows just written.
 func (db *Database) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	if tx := db.txOf(ctx); tx != nil {
+		return tx.SelectContext(ctx, dest, query, args...)
+	}
+
 	var err error
 	ctx = db.before(ctx, query, args...)
-	err = db.DB.SelectContext(ctx, dest, query, args...)
+	pool := db.reader(ctx, query)
+	err = db.withStmt(ctx, pool, query, func(stmt *sqlx.Stmt) error {
+		if stmt == nil {
+			return pool.SelectContext(ctx, dest, query, args...)
+		}
+		return stmt.SelectContext(ctx, dest, args...)
+	})
 	err = db.after(ctx, err, query, args...)
 	return err
 }
@@ -92,9 +159,19 @@ func (db *Database) Select(dest interface{}, query string, args ...interface{})
 }
 
 func (db *Database) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	if tx := db.txOf(ctx); tx != nil {
+		return tx.GetContext(ctx, dest, query, args...)
+	}
+
 	var err error
 	ctx = db.before(ctx, query, args...)
-	err = db.DB.GetContext(ctx, dest, query, args...)
+	pool := db.reader(ctx, query)
+	err = db.withStmt(ctx, pool, query, func(stmt *sqlx.Stmt) error {
+		if stmt == nil {
+			return pool.GetContext(ctx, dest, query, args...)
+		}
+		return stmt.GetContext(ctx, dest, args...)
+	})
 	err = db.after(ctx, err, query, args...)
 	return err
 }
@@ -104,9 +181,20 @@ func (db *Database) Get(dest interface{}, query string, args ...interface{}) err
 }
 
 func (db *Database) ExecContext(ctx context.Context, executeSql string, args ...interface{}) (sql.Result, error) {
-	var err error
+	if tx := db.txOf(ctx); tx != nil {
+		return tx.ExecContext(ctx, executeSql, args...)
+	}
+
+	var result sql.Result
 	ctx = db.before(ctx, executeSql, args...)
-	result, err := db.DB.ExecContext(ctx, executeSql, args...)
+	err := db.withStmt(ctx, db.DB, executeSql, func(stmt *sqlx.Stmt) (err error) {
+		if stmt == nil {
+			result, err = db.DB.ExecContext(ctx, executeSql, args...)
+		} else {
+			result, err = stmt.ExecContext(ctx, args...)
+		}
+		return err
+	})
 	err = db.after(ctx, err, executeSql, args...)
 	return result, err
 }
@@ -116,10 +204,23 @@ func (db *Database) Exec(query string, args ...interface{}) (sql.Result, error)
 }
 
 func (db *Database) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if tx := db.txOf(ctx); tx != nil {
+		return tx.QueryContext(ctx, query, args...)
+	}
+
+	var rows *sql.Rows
 	ctx = db.before(ctx, query, args...)
-	rows, err := db.DB.QueryContext(ctx, query, args...)
+	pool := db.reader(ctx, query)
+	err := db.withStmt(ctx, pool, query, func(stmt *sqlx.Stmt) (err error) {
+		if stmt == nil {
+			rows, err = pool.QueryContext(ctx, query, args...)
+		} else {
+			rows, err = stmt.QueryContext(ctx, args...)
+		}
+		return err
+	})
 	err = db.after(ctx, err, query, args...)
-	return rows, nil
+	return rows, err
 }
 
 func (db *Database) Query(query string, args ...interface{}) (*sql.Rows, error) {
@@ -127,8 +228,21 @@ func (db *Database) Query(query string, args ...interface{}) (*sql.Rows, error)
 }
 
 func (db *Database) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	if tx := db.txOf(ctx); tx != nil {
+		return tx.QueryxContext(ctx, query, args...)
+	}
+
+	var rows *sqlx.Rows
 	ctx = db.before(ctx, query, args...)
-	rows, err := db.DB.QueryxContext(ctx, query, args...)
+	pool := db.reader(ctx, query)
+	err := db.withStmt(ctx, pool, query, func(stmt *sqlx.Stmt) (err error) {
+		if stmt == nil {
+			rows, err = pool.QueryxContext(ctx, query, args...)
+		} else {
+			rows, err = stmt.QueryxContext(ctx, args...)
+		}
+		return err
+	})
 	err = db.after(ctx, err, query, args...)
 	return rows, err
 }
@@ -138,9 +252,22 @@ func (db *Database) Queryx(query string, args ...interface{}) (*sqlx.Rows, error
 }
 
 func (db *Database) QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row {
+	if tx := db.txOf(ctx); tx != nil {
+		return tx.QueryRowxContext(ctx, query, args...)
+	}
+
+	var rows *sqlx.Row
 	ctx = db.before(ctx, query, args...)
-	rows := db.DB.QueryRowxContext(ctx, query, args...)
-	_ = db.after(ctx, rows.Err(), query, args...)
+	pool := db.reader(ctx, query)
+	err := db.withStmt(ctx, pool, query, func(stmt *sqlx.Stmt) error {
+		if stmt == nil {
+			rows = pool.QueryRowxContext(ctx, query, args...)
+		} else {
+			rows = stmt.QueryRowxContext(ctx, args...)
+		}
+		return rows.Err()
+	})
+	_ = db.after(ctx, err, query, args...)
 	return rows
 }
 
@@ -149,6 +276,10 @@ func (db *Database) QueryRowx(query string, args ...interface{}) *sqlx.Row {
 }
 
 func (db *Database) Close() error {
+	if db.stmts != nil {
+		db.stmts.close()
+	}
+	db.closeReplicas()
 	err := db.DB.Close()
 	if err != nil {
 		db.Logger.Errorf("close connection fail: %+v", err)
@@ -157,12 +288,48 @@ func (db *Database) Close() error {
 	return nil
 }
 
+// SetDatabaseHook replaces all the hooks with the hook
 func (db *Database) SetDatabaseHook(hook DatabaseHook) {
 	db.Hook = hook
 }
 
+// AddHook appends the hooks, the hooks are chained by a HookChain if there are more than one
+func (db *Database) AddHook(hooks ...DatabaseHook) {
+	chain := NewHookChain(db.Hook)
+	chain.Append(hooks...)
+	switch len(chain.hooks) {
+	case 0:
+		db.Hook = nil
+	case 1:
+		db.Hook = chain.hooks[0]
+	default:
+		db.Hook = chain
+	}
+}
+
+// SetDatabaseLoggerHook appends the DatabaseLoggerHook to the hooks, it does nothing
+// if a DatabaseLoggerHook or SlowQueryHook is already added, the statements are logged once
 func (db *Database) SetDatabaseLoggerHook() {
-	db.Hook = &DatabaseLoggerHook{db.Logger}
+	for _, hook := range NewHookChain(db.Hook).Hooks() {
+		switch hook.(type) {
+		case *DatabaseLoggerHook, *SlowQueryHook:
+			return
+		}
+	}
+	db.AddHook(&DatabaseLoggerHook{Logger: db.Logger})
+}
+
+// SetSlowQueryHook appends the SlowQueryHook with the threshold, the plans of the slow queries
+// are logged if explain is true. The statistics are available by the Stats of the returned hook.
+// It logs the statements as the DatabaseLoggerHook, so it's unnecessary to add both of them.
+func (db *Database) SetSlowQueryHook(threshold time.Duration, explain bool) *SlowQueryHook {
+	var opts []SlowQueryOption
+	if explain {
+		opts = append(opts, WithExplain(db, defaultExplainWorker))
+	}
+	hook := NewSlowQueryHook(db.Logger, threshold, opts...)
+	db.AddHook(hook)
+	return hook
 }
 
 func FetchDB(dbName string) *Database {
@@ -203,11 +370,34 @@ func (db *Database) Connect() error {
 		db.Logger = logger.NewLogger(logger.WithPrefix("DB.%s", strings.ToUpper(config.DBName)))
 	}
 
-	sdb, err := sqlx.Open(config.Driver, config.DSN)
+	sdb, err := db.open(config.DSN)
+	if err != nil {
+		db.Logger.Errorf("connect [%s] fail: %+v", config.DBName, err)
+		return err
+	}
+
+	err = sdb.Ping()
 	if err != nil {
-		db.Logger.Errorf("connect fail: %+v", config.DBName, err)
+		db.Logger.Errorf("ping fail: %+v", err)
 		return err
 	}
+
+	db.DB = sdb
+	db.Logger.Debugf(color.New(color.Bold, color.OpUnderscore, color.FgGreen).Sprintf("database connect successfully: [%s]", config.DesensitiseDSN))
+	if db.Hook != nil {
+		db.Hook.SetLogger(db.Logger)
+	}
+	db.connectReplicas()
+	dbs[config.DBName] = db
+	return nil
+}
+
+// open opens the connection pool of the dsn with the options of the config
+func (db *Database) open(dsn string) (*sqlx.DB, error) {
+	sdb, err := sqlx.Open(db.config.Driver, dsn)
+	if err != nil {
+		return nil, err
+	}
 	if db.config.Options.MaxOpenConns > 0 {
 		sdb.SetMaxOpenConns(db.config.Options.MaxOpenConns)
 	}
@@ -220,18 +410,39 @@ func (db *Database) Connect() error {
 	if db.config.Options.ConnMaxLifetime.Nanoseconds() > 0 {
 		sdb.SetConnMaxLifetime(db.config.Options.ConnMaxLifetime)
 	}
+	return sdb, nil
+}
 
-	err = sdb.Ping()
-	if err != nil {
-		db.Logger.Errorf("ping fail: %+v", err)
-		return err
+// connectReplicas opens the replicas of the config and starts pinging them,
+// the replicas failed to ping are out of rotation until the next successful ping
+func (db *Database) connectReplicas() {
+	if len(db.config.Replicas) == 0 {
+		return
 	}
 
-	db.DB = sdb
-	db.Logger.Debugf(color.New(color.Bold, color.OpUnderscore, color.FgGreen).Sprintf("database connect successfully: [%s]", config.DesensitiseDSN))
-	if db.Hook != nil {
-		db.Hook.SetLogger(db.Logger)
+	interval := db.config.Options.ReplicaPingInterval
+	if interval <= 0 {
+		interval = defaultReplicaPingInterval
 	}
-	dbs[config.DBName] = db
-	return nil
+
+	rs := &replicas{strategy: parseReplicaStrategy(db.config.Options.ReplicaStrategy), closing: make(chan struct{})}
+	for _, config := range db.config.Replicas {
+		sdb, err := db.open(config.DSN)
+		if err != nil {
+			db.Logger.Errorf("connect replica [%s] fail: %+v", config.DesensitiseDSN, err)
+			continue
+		}
+
+		r := newReplica(config.DesensitiseDSN, sdb)
+		if _, err = r.ping(interval); err != nil {
+			db.Logger.Warnf("replica [%s] is out of rotation: %+v", r.name, err)
+		}
+		rs.nodes = append(rs.nodes, r)
+	}
+	if len(rs.nodes) == 0 {
+		return
+	}
+
+	db.replicas = rs
+	go db.pingReplicas(interval)
 }
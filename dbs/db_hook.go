@@ -2,10 +2,7 @@ package dbs
 
 import (
 	"context"
-	"fmt"
 	"github.com/transerver/commons/logger"
-	"strconv"
-	"strings"
 	"time"
 )
 
@@ -18,6 +15,11 @@ type DatabaseHook interface {
 
 type DatabaseLoggerHook struct {
 	Logger *logger.Logger
+
+	// Dialect of the logged statements, it's detected by the statement if unset
+	Dialect Dialect
+	// SensitiveColumns the columns in lower case whose arguments are redacted in logs
+	SensitiveColumns map[string]struct{}
 }
 
 func (h *DatabaseLoggerHook) Before(ctx context.Context, query string, args ...interface{}) (context.Context, error) {
@@ -31,9 +33,9 @@ func (h *DatabaseLoggerHook) After(ctx context.Context, query string, args ...in
 
 	if startTime, ok := ctx.Value("startTime").(time.Time); ok {
 		took := time.Since(startTime)
-		h.Logger.Debugf("SQL: %s, Took: %s", expansionArgs(query, args...), took)
+		h.Logger.Debugf("SQL: %s, Took: %s", h.format(query, args...), took)
 	} else {
-		h.Logger.Debugf("SQL: %s", expansionArgs(query, args...))
+		h.Logger.Debugf("SQL: %s", h.format(query, args...))
 	}
 	return ctx, nil
 }
@@ -41,9 +43,9 @@ func (h *DatabaseLoggerHook) After(ctx context.Context, query string, args ...in
 func (h *DatabaseLoggerHook) OnError(ctx context.Context, err error, query string, args ...interface{}) {
 	if startTime, ok := ctx.Value("startTime").(time.Time); ok {
 		took := time.Since(startTime)
-		h.Logger.Errorf("SQL: %s, Took: %s, Error: %+v", expansionArgs(query, args...), took, err)
+		h.Logger.Errorf("SQL: %s, Took: %s, Error: %+v", h.format(query, args...), took, err)
 	} else {
-		h.Logger.Errorf("SQL: %s, Error: %+v", expansionArgs(query, args...), err)
+		h.Logger.Errorf("SQL: %s, Error: %+v", h.format(query, args...), err)
 	}
 }
 
@@ -51,11 +53,9 @@ func (h *DatabaseLoggerHook) SetLogger(logger *logger.Logger) {
 	h.Logger = logger
 }
 
-func expansionArgs(query string, args ...interface{}) string {
-	for i, arg := range args {
-		query = strings.Replace(query, "$"+strconv.Itoa(i+1), fmt.Sprintf("`%+v`", arg), 1)
-	}
-	return query
+// format returns the statement with the arguments interpolated, see Interpolate
+func (h *DatabaseLoggerHook) format(query string, args ...interface{}) string {
+	return Interpolate(h.Dialect, query, args, h.SensitiveColumns)
 }
 
 func (db *Database) before(ctx context.Context, query string, args ...interface{}) context.Context {
@@ -67,8 +67,11 @@ func (db *Database) before(ctx context.Context, query string, args ...interface{
 	}
 
 	bctx, err := db.Hook.Before(ctx, query, args...)
+	if bctx == nil {
+		bctx = ctx
+	}
 	if err != nil {
-		db.Hook.OnError(bctx, err, query, args...)
+		db.hookFailed(bctx, err, query, args...)
 	}
 	return bctx
 }
@@ -84,7 +87,18 @@ func (db *Database) after(ctx context.Context, err error, query string, args ...
 	}
 	_, err = db.Hook.After(ctx, query, args...)
 	if err != nil {
-		db.Hook.OnError(ctx, err, query, args...)
+		db.hookFailed(ctx, err, query, args...)
+		if db.hookErrorPolicy == HookErrorIgnore {
+			return nil
+		}
 	}
 	return err
 }
+
+// hookFailed passes the error of the hook to its OnError,
+// the HookChain has passed the errors to the OnError of the hooks failed already
+func (db *Database) hookFailed(ctx context.Context, err error, query string, args ...interface{}) {
+	if _, ok := db.Hook.(*HookChain); !ok {
+		db.Hook.OnError(ctx, err, query, args...)
+	}
+}
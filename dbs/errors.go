@@ -0,0 +1,125 @@
+package dbs
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"io"
+	"net"
+)
+
+// ErrorClass is the class of a database error, classified by the SQLSTATE of Postgres
+// and the error number of MySQL
+type ErrorClass int
+
+const (
+	ClassUnknown ErrorClass = iota
+	// ClassRetryable the transaction failed by a serialization failure, a deadlock or a lock wait timeout,
+	// it may succeed when retried
+	ClassRetryable
+	ClassUniqueViolation
+	ClassForeignKeyViolation
+	ClassNotNullViolation
+	// ClassConnection the connection is broken or refused
+	ClassConnection
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ClassRetryable:
+		return "retryable"
+	case ClassUniqueViolation:
+		return "unique violation"
+	case ClassForeignKeyViolation:
+		return "foreign key violation"
+	case ClassNotNullViolation:
+		return "not null violation"
+	case ClassConnection:
+		return "connection"
+	default:
+		return "unknown"
+	}
+}
+
+// Classify returns the class of the err, the wrapped errors are unwrapped.
+// Returns ClassUnknown if err is nil or not recognized.
+func Classify(err error) ErrorClass {
+	if err == nil {
+		return ClassUnknown
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return classifyPostgres(pqErr)
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return classifyMySQL(mysqlErr)
+	}
+
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr) {
+		return ClassConnection
+	}
+	return ClassUnknown
+}
+
+func classifyPostgres(err *pq.Error) ErrorClass {
+	switch err.Code {
+	case "40001", "40P01": // serialization_failure, deadlock_detected
+		return ClassRetryable
+	case "23505":
+		return ClassUniqueViolation
+	case "23503":
+		return ClassForeignKeyViolation
+	case "23502":
+		return ClassNotNullViolation
+	case "57P01", "57P02", "57P03": // admin_shutdown, crash_shutdown, cannot_connect_now
+		return ClassConnection
+	}
+	if err.Code.Class() == "08" { // connection_exception
+		return ClassConnection
+	}
+	return ClassUnknown
+}
+
+func classifyMySQL(err *mysql.MySQLError) ErrorClass {
+	switch err.Number {
+	case 1213, 1205: // ER_LOCK_DEADLOCK, ER_LOCK_WAIT_TIMEOUT
+		return ClassRetryable
+	case 1062: // ER_DUP_ENTRY
+		return ClassUniqueViolation
+	case 1216, 1217, 1451, 1452: // ER_NO_REFERENCED_ROW, ER_ROW_IS_REFERENCED and the _2 ones
+		return ClassForeignKeyViolation
+	case 1048: // ER_BAD_NULL_ERROR
+		return ClassNotNullViolation
+	case 1040, 1053: // ER_CON_COUNT_ERROR, ER_SERVER_SHUTDOWN
+		return ClassConnection
+	}
+	return ClassUnknown
+}
+
+// IsRetryable reports whether the transaction failed with err may succeed when retried
+func IsRetryable(err error) bool {
+	return Classify(err) == ClassRetryable
+}
+
+func IsUniqueViolation(err error) bool {
+	return Classify(err) == ClassUniqueViolation
+}
+
+func IsForeignKeyViolation(err error) bool {
+	return Classify(err) == ClassForeignKeyViolation
+}
+
+func IsNotNullViolation(err error) bool {
+	return Classify(err) == ClassNotNullViolation
+}
+
+func IsConnectionError(err error) bool {
+	return Classify(err) == ClassConnection
+}
@@ -0,0 +1,95 @@
+package dbs
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		err   error
+		class ErrorClass
+	}{
+		{nil, ClassUnknown},
+		{errors.New("unknown"), ClassUnknown},
+		{&pq.Error{Code: "40001"}, ClassRetryable},
+		{&pq.Error{Code: "40P01"}, ClassRetryable},
+		{&pq.Error{Code: "23505"}, ClassUniqueViolation},
+		{&pq.Error{Code: "23503"}, ClassForeignKeyViolation},
+		{&pq.Error{Code: "23502"}, ClassNotNullViolation},
+		{&pq.Error{Code: "08006"}, ClassConnection},
+		{&pq.Error{Code: "42601"}, ClassUnknown},
+		{&mysql.MySQLError{Number: 1213}, ClassRetryable},
+		{&mysql.MySQLError{Number: 1205}, ClassRetryable},
+		{&mysql.MySQLError{Number: 1062}, ClassUniqueViolation},
+		{&mysql.MySQLError{Number: 1452}, ClassForeignKeyViolation},
+		{&mysql.MySQLError{Number: 1048}, ClassNotNullViolation},
+		{mysql.ErrInvalidConn, ClassConnection},
+		{driver.ErrBadConn, ClassConnection},
+		{fmt.Errorf("insert: %w", &pq.Error{Code: "23505"}), ClassUniqueViolation},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.class, Classify(c.err), "%v", c.err)
+	}
+	require.True(t, IsRetryable(&pq.Error{Code: "40001"}))
+	require.False(t, IsUniqueViolation(&pq.Error{Code: "40001"}))
+}
+
+func TestInTxRetry(t *testing.T) {
+	db, mock, _ := newMockDatabase(t)
+	WithTxRetry(3, time.Millisecond, time.Millisecond*5)(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE accounts").WillReturnError(&pq.Error{Code: "40001"})
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE accounts").WillReturnError(&pq.Error{Code: "40P01"})
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	runs := 0
+	err := db.InTx(context.Background(), nil, func(tx *Tx) error {
+		runs++
+		_, err := tx.Exec("UPDATE accounts SET balance = balance - 1")
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, runs)
+}
+
+func TestInTxRetryExhausted(t *testing.T) {
+	db, mock, _ := newMockDatabase(t)
+	WithTxRetry(2, time.Millisecond, time.Millisecond)(db)
+
+	for i := 0; i < 2; i++ {
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE accounts").WillReturnError(&mysql.MySQLError{Number: 1213})
+		mock.ExpectRollback()
+	}
+
+	err := db.InTx(context.Background(), nil, func(tx *Tx) error {
+		_, err := tx.Exec("UPDATE accounts SET balance = balance - 1")
+		return err
+	})
+	require.True(t, IsRetryable(err))
+
+	// the errors not retryable are returned at once
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO accounts").WillReturnError(&pq.Error{Code: "23505"})
+	mock.ExpectRollback()
+	err = db.InTx(context.Background(), nil, func(tx *Tx) error {
+		_, err := tx.Exec("INSERT INTO accounts DEFAULT VALUES")
+		return err
+	})
+	require.True(t, IsUniqueViolation(err))
+}
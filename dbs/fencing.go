@@ -0,0 +1,50 @@
+package dbs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// ErrFenced is returned when a fenced statement affects no rows but the row exists,
+// which means the row has been written by a newer lock holder.
+var ErrFenced = errors.New("dbs: fenced by a newer fencing token")
+
+// ExecFencedContext executes the statement guarded by the fencing token of a lock.
+// The statement must store the token and only match the rows with an older or the same token,
+// so the lock holder can write the row more than once, e.g.
+//
+//	UPDATE orders SET status = $1, fencing_token = $2 WHERE id = $3 AND fencing_token <= $2
+//
+// If no rows affected, the exists query is queried with the existsArgs to check whether the row exists, e.g.
+//
+//	SELECT EXISTS(SELECT 1 FROM orders WHERE id = $1)
+//
+// ErrFenced is returned if the row exists, otherwise sql.ErrNoRows.
+func (db *Database) ExecFencedContext(ctx context.Context, query string, args []interface{}, exists string, existsArgs ...interface{}) (sql.Result, error) {
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return result, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return result, err
+	}
+	if affected > 0 {
+		return result, nil
+	}
+
+	var found bool
+	if err = db.GetContext(ForcePrimary(ctx), &found, exists, existsArgs...); err != nil {
+		return result, err
+	}
+	if found {
+		return result, ErrFenced
+	}
+	return result, sql.ErrNoRows
+}
+
+func (db *Database) ExecFenced(query string, args []interface{}, exists string, existsArgs ...interface{}) (sql.Result, error) {
+	return db.ExecFencedContext(context.Background(), query, args, exists, existsArgs...)
+}
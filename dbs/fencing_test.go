@@ -0,0 +1,37 @@
+package dbs
+
+import (
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestExecFenced(t *testing.T) {
+	db, mock, _ := newMockDatabase(t)
+
+	update := "UPDATE orders SET status = $1, fencing_token = $2 WHERE id = $3 AND fencing_token <= $2"
+	exists := "SELECT EXISTS(SELECT 1 FROM orders WHERE id = $1)"
+	existsRows := func(found bool) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"exists"}).AddRow(found)
+	}
+
+	mock.ExpectExec("UPDATE orders").WithArgs("paid", 7, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE orders").WithArgs("shipped", 7, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE orders").WithArgs("paid", 6, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(1).WillReturnRows(existsRows(true))
+	mock.ExpectExec("UPDATE orders").WithArgs("paid", 7, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(2).WillReturnRows(existsRows(false))
+
+	// the same holder writes the row twice
+	_, err := db.ExecFenced(update, []interface{}{"paid", 7, 1}, exists, 1)
+	require.NoError(t, err)
+	_, err = db.ExecFenced(update, []interface{}{"shipped", 7, 1}, exists, 1)
+	require.NoError(t, err)
+
+	_, err = db.ExecFenced(update, []interface{}{"paid", 6, 1}, exists, 1)
+	require.ErrorIs(t, err, ErrFenced)
+
+	_, err = db.ExecFenced(update, []interface{}{"paid", 7, 2}, exists, 2)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
@@ -0,0 +1,116 @@
+package dbs
+
+import (
+	"context"
+	"github.com/transerver/commons/logger"
+	"strings"
+)
+
+// HookErrorPolicy controls whether the errors of the hooks fail the statements,
+// the errors are always passed to the OnError of the hooks failed, the other hooks never see them
+type HookErrorPolicy int
+
+const (
+	// HookErrorReturn the error of the After is returned as the error of the statement
+	HookErrorReturn HookErrorPolicy = iota
+	// HookErrorIgnore the errors of the hooks never fail the statements
+	HookErrorIgnore
+)
+
+// HookErrors is the errors of the hooks in a HookChain
+type HookErrors []error
+
+func (e HookErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// HookChain runs the hooks in order, the Before of each hook sees the context enriched by the previous ones.
+// The After and OnError are run in the reverse order, like the middlewares.
+// The error of a hook doesn't stop the others, it's passed to the OnError of the hook failed only,
+// and the errors are returned together.
+type HookChain struct {
+	hooks []DatabaseHook
+}
+
+var _ DatabaseHook = (*HookChain)(nil)
+
+func NewHookChain(hooks ...DatabaseHook) *HookChain {
+	c := &HookChain{}
+	c.Append(hooks...)
+	return c
+}
+
+// Append appends the hooks to the end of the chain, the hooks of the appended chains are flattened
+func (c *HookChain) Append(hooks ...DatabaseHook) {
+	for _, hook := range hooks {
+		switch h := hook.(type) {
+		case nil:
+		case *HookChain:
+			c.hooks = append(c.hooks, h.hooks...)
+		default:
+			c.hooks = append(c.hooks, h)
+		}
+	}
+}
+
+func (c *HookChain) Hooks() []DatabaseHook {
+	return c.hooks
+}
+
+func (c *HookChain) Before(ctx context.Context, query string, args ...interface{}) (context.Context, error) {
+	var errs HookErrors
+	for _, hook := range c.hooks {
+		hctx, err := hook.Before(ctx, query, args...)
+		if hctx != nil {
+			ctx = hctx
+		}
+		if err != nil {
+			hook.OnError(ctx, err, query, args...)
+			errs = append(errs, err)
+		}
+	}
+	return ctx, errs.err()
+}
+
+func (c *HookChain) After(ctx context.Context, query string, args ...interface{}) (context.Context, error) {
+	var errs HookErrors
+	for i := len(c.hooks) - 1; i >= 0; i-- {
+		hctx, err := c.hooks[i].After(ctx, query, args...)
+		if hctx != nil {
+			ctx = hctx
+		}
+		if err != nil {
+			c.hooks[i].OnError(ctx, err, query, args...)
+			errs = append(errs, err)
+		}
+	}
+	return ctx, errs.err()
+}
+
+func (c *HookChain) OnError(ctx context.Context, err error, query string, args ...interface{}) {
+	for i := len(c.hooks) - 1; i >= 0; i-- {
+		c.hooks[i].OnError(ctx, err, query, args...)
+	}
+}
+
+func (c *HookChain) SetLogger(logger *logger.Logger) {
+	for _, hook := range c.hooks {
+		hook.SetLogger(logger)
+	}
+}
+
+// err returns nil if no errors, the error itself if only one
+func (e HookErrors) err() error {
+	switch len(e) {
+	case 0:
+		return nil
+	case 1:
+		return e[0]
+	default:
+		return e
+	}
+}
@@ -0,0 +1,110 @@
+package dbs
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"github.com/transerver/commons/logger"
+	"testing"
+	"time"
+)
+
+type traceKey struct{}
+
+// traceHook enriches the context with its name, and records the names seen in the context
+type traceHook struct {
+	recordHook
+	name     string
+	seen     []string
+	afters   []string
+	afterErr error
+}
+
+func (h *traceHook) Before(ctx context.Context, query string, args ...interface{}) (context.Context, error) {
+	trace, _ := ctx.Value(traceKey{}).(string)
+	h.seen = append(h.seen, trace)
+	return context.WithValue(ctx, traceKey{}, trace+h.name), nil
+}
+
+func (h *traceHook) After(ctx context.Context, query string, args ...interface{}) (context.Context, error) {
+	h.afters = append(h.afters, query)
+	return ctx, h.afterErr
+}
+
+func TestHookChain(t *testing.T) {
+	log := recordHook{DatabaseLoggerHook: DatabaseLoggerHook{Logger: logger.NewLogger(logger.WithPrefix("DB.MOCK"))}}
+	first := &traceHook{recordHook: log, name: "a", afterErr: errors.New("metrics unavailable")}
+	second := &traceHook{recordHook: log, name: "b"}
+	db, mock, _ := newMockDatabase(t)
+	db.SetDatabaseHook(nil)
+	WithHook(first)(db)
+	WithHook(second)(db)
+	require.Len(t, db.Hook.(*HookChain).Hooks(), 2)
+
+	mock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, err := db.Exec("UPDATE users SET active = true")
+	require.EqualError(t, err, "metrics unavailable")
+	require.Equal(t, []string{""}, first.seen)
+	require.Equal(t, []string{"a"}, second.seen)
+	require.Equal(t, []string{"UPDATE users SET active = true"}, second.afters)
+	require.Len(t, first.errors, 1)
+	require.Empty(t, second.errors)
+
+	WithHookErrorPolicy(HookErrorIgnore)(db)
+	_, err = db.Exec("UPDATE users SET active = true")
+	require.NoError(t, err)
+	require.Len(t, first.errors, 2)
+	require.Empty(t, second.errors)
+}
+
+func TestHookChainSlowQuery(t *testing.T) {
+	log := recordHook{DatabaseLoggerHook: DatabaseLoggerHook{Logger: logger.NewLogger(logger.WithPrefix("DB.MOCK"))}}
+	failing := &traceHook{recordHook: log, name: "a", afterErr: errors.New("metrics unavailable")}
+	slow := NewSlowQueryHook(log.Logger, time.Hour)
+	db, mock, _ := newMockDatabase(t)
+	db.SetDatabaseHook(nil)
+	WithHook(failing, slow)(db)
+
+	mock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE users").WillReturnError(errors.New("deadlock"))
+
+	_, err := db.Exec("UPDATE users SET active = true")
+	require.EqualError(t, err, "metrics unavailable")
+	_, err = db.Exec("UPDATE users SET active = true")
+	require.EqualError(t, err, "deadlock")
+
+	// the statements are recorded once each, the error of the failing hook isn't recorded
+	stats := slow.Stats()
+	require.Len(t, stats, 1)
+	require.EqualValues(t, 2, stats[0].Count)
+	require.EqualValues(t, 1, stats[0].Errors)
+	require.Len(t, failing.errors, 2)
+}
+
+func TestAddHook(t *testing.T) {
+	db := NewDatabase()
+	require.Nil(t, db.Hook)
+
+	hook := &recordHook{}
+	db.AddHook(hook)
+	require.Same(t, hook, db.Hook)
+
+	db.SetDatabaseLoggerHook()
+	db.SetDatabaseLoggerHook()
+	chain, ok := db.Hook.(*HookChain)
+	require.True(t, ok)
+	require.Len(t, chain.Hooks(), 2)
+
+	db.AddHook(NewHookChain(&recordHook{}, &recordHook{}))
+	require.Len(t, db.Hook.(*HookChain).Hooks(), 4)
+}
+
+func TestSetDatabaseLoggerHookAfterSlowQuery(t *testing.T) {
+	db := NewDatabase()
+	slow := db.SetSlowQueryHook(time.Second, false)
+	db.SetDatabaseLoggerHook()
+	require.Same(t, slow, db.Hook)
+}
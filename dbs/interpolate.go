@@ -0,0 +1,412 @@
+package dbs
+
+import (
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const redacted = "[***]"
+
+// Dialect is the SQL dialect of the placeholders, string literals and comments
+type Dialect int
+
+const (
+	// DialectAuto detects the dialect by the query, it's Postgres if there are $n placeholders, otherwise MySQL
+	DialectAuto Dialect = iota
+	// DialectPostgres the placeholders are $n, the double quotes are identifiers
+	DialectPostgres
+	// DialectMySQL the placeholders are ?, the double quotes are strings and the backslashes escape
+	DialectMySQL
+)
+
+// Sensitive is implemented by the arguments redacted in logs
+type Sensitive interface {
+	Sensitive() bool
+}
+
+// Secret is a string argument redacted in logs, e.g.
+//
+//	db.Exec("UPDATE users SET token = $1 WHERE id = $2", dbs.Secret(token), id)
+type Secret string
+
+func (s Secret) Sensitive() bool {
+	return true
+}
+
+func (s Secret) Value() (driver.Value, error) {
+	return string(s), nil
+}
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokString
+	tokNumber
+	tokPlaceholder
+	tokPunct
+)
+
+type token struct {
+	kind       tokenKind
+	text       string
+	start, end int
+	// arg the index of the argument of the placeholder
+	arg int
+}
+
+// Interpolate returns the query with the placeholders replaced by the quoted args for logging,
+// the placeholders in the string literals and comments are kept. The args are redacted if they're Sensitive,
+// or they're compared with or inserted into the sensitiveColumns, which are in lower case.
+func Interpolate(dialect Dialect, query string, args []interface{}, sensitiveColumns map[string]struct{}) string {
+	if len(args) == 0 {
+		return query
+	}
+
+	var tokens []token
+	if dialect == DialectAuto {
+		tokens = tokenize(DialectPostgres, query)
+		dialect = DialectPostgres
+		if !hasPlaceholder(tokens) {
+			tokens = tokenize(DialectMySQL, query)
+			dialect = DialectMySQL
+		}
+	} else {
+		tokens = tokenize(dialect, query)
+	}
+
+	var columns []string
+	if len(sensitiveColumns) > 0 {
+		columns = placeholderColumns(tokens, len(args))
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, tok := range tokens {
+		if tok.kind != tokPlaceholder || tok.arg < 0 || tok.arg >= len(args) {
+			continue
+		}
+		b.WriteString(query[last:tok.start])
+		last = tok.end
+
+		arg := args[tok.arg]
+		sensitive := isSensitive(arg)
+		if !sensitive && columns != nil && columns[tok.arg] != "" {
+			_, sensitive = sensitiveColumns[columns[tok.arg]]
+		}
+		if sensitive {
+			b.WriteString(redacted)
+		} else {
+			b.WriteString(quoteValue(dialect, arg))
+		}
+	}
+	b.WriteString(query[last:])
+	return b.String()
+}
+
+func hasPlaceholder(tokens []token) bool {
+	for _, tok := range tokens {
+		if tok.kind == tokPlaceholder {
+			return true
+		}
+	}
+	return false
+}
+
+func isSensitive(arg interface{}) bool {
+	s, ok := arg.(Sensitive)
+	return ok && s.Sensitive()
+}
+
+// tokenize splits the query into the tokens, the whitespaces and comments are skipped
+func tokenize(dialect Dialect, query string) []token {
+	var tokens []token
+	mysql := dialect == DialectMySQL
+	n := len(query)
+	next := 0
+
+	for i := 0; i < n; {
+		c := query[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+			continue
+		case c == '-' && i+1 < n && query[i+1] == '-', c == '#' && mysql:
+			for i < n && query[i] != '\n' {
+				i++
+			}
+			continue
+		case c == '/' && i+1 < n && query[i+1] == '*':
+			if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+				i += end + 4
+			} else {
+				i = n
+			}
+			continue
+		case c == '\'' || c == '"' && mysql:
+			i = skipQuoted(query, i, c, mysql)
+			tokens = append(tokens, token{kind: tokString, text: query[start:i], start: start, end: i})
+		case c == '"' || c == '`' && mysql:
+			i = skipQuoted(query, i, c, false)
+			tokens = append(tokens, token{kind: tokWord, text: query[start+1 : i-1], start: start, end: i})
+		case c == '$' && !mysql && i+1 < n && isDigit(query[i+1]):
+			i++
+			for i < n && isDigit(query[i]) {
+				i++
+			}
+			index, _ := strconv.Atoi(query[start+1 : i])
+			tokens = append(tokens, token{kind: tokPlaceholder, text: query[start:i], start: start, end: i, arg: index - 1})
+		case c == '$' && !mysql && dollarTag(query[i:]) != "":
+			tag := dollarTag(query[i:])
+			if end := strings.Index(query[i+len(tag):], tag); end >= 0 {
+				i += len(tag) + end + len(tag)
+			} else {
+				i = n
+			}
+			tokens = append(tokens, token{kind: tokString, text: query[start:i], start: start, end: i})
+		case c == '?' && mysql:
+			i++
+			tokens = append(tokens, token{kind: tokPlaceholder, text: "?", start: start, end: i, arg: next})
+			next++
+		case isDigit(c):
+			for i < n && (isDigit(query[i]) || query[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: query[start:i], start: start, end: i})
+		case isWordByte(c):
+			for i < n && (isWordByte(query[i]) || isDigit(query[i]) || query[i] == '$') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokWord, text: query[start:i], start: start, end: i})
+		default:
+			i++
+			if i < n {
+				switch query[start : i+1] {
+				case "<=", ">=", "<>", "!=", "::", "||":
+					i++
+				}
+			}
+			tokens = append(tokens, token{kind: tokPunct, text: query[start:i], start: start, end: i})
+		}
+	}
+	return tokens
+}
+
+// skipQuoted returns the position after the quoted string starting at i,
+// the quote is escaped by doubled quotes, and by the backslash if backslash is true
+func skipQuoted(query string, i int, quote byte, backslash bool) int {
+	for i++; i < len(query); i++ {
+		switch {
+		case backslash && query[i] == '\\':
+			i++
+		case query[i] == quote && i+1 < len(query) && query[i+1] == quote:
+			i++
+		case query[i] == quote:
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+// dollarTag returns the tag of the dollar quoted string of Postgres at the start of s, e.g. $$ or $body$
+func dollarTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		if s[i] == '$' {
+			return s[:i+1]
+		}
+		if !isWordByte(s[i]) && !(i > 1 && isDigit(s[i])) {
+			return ""
+		}
+	}
+	return ""
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+var comparisons = map[string]struct{}{
+	"=": {}, "<>": {}, "!=": {}, "<": {}, ">": {}, "<=": {}, ">=": {}, "LIKE": {}, "ILIKE": {},
+}
+
+// placeholderColumns returns the columns of the placeholders in lower case, the column of a placeholder
+// is the one it's compared with, e.g. name = $1, name IN ($1, $2), or inserted into by INSERT ... VALUES
+func placeholderColumns(tokens []token, args int) []string {
+	columns := make([]string, args)
+	setColumn := func(tok token, column string) {
+		if tok.arg >= 0 && tok.arg < args {
+			columns[tok.arg] = strings.ToLower(column)
+		}
+	}
+
+	// the columns of the IN lists of the open parentheses, empty if it's not an IN list
+	var inLists []string
+	var insertColumns []string
+	valuesDepth, position := -1, 0
+
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch {
+		case tok.kind == tokWord && (strings.EqualFold(tok.text, "INSERT") || strings.EqualFold(tok.text, "REPLACE")):
+			if cols, values := insertInto(tokens, i); values > 0 {
+				insertColumns = cols
+				valuesDepth, position = 0, 0
+				i = values
+			}
+		case valuesDepth >= 0:
+			switch {
+			case tok.text == "(":
+				valuesDepth++
+				if valuesDepth == 1 {
+					position = 0
+				}
+			case tok.text == ")":
+				valuesDepth--
+			case tok.text == "," && valuesDepth == 1:
+				position++
+			case tok.kind == tokPlaceholder && valuesDepth >= 1 && position < len(insertColumns):
+				setColumn(tok, insertColumns[position])
+			case tok.kind == tokWord && valuesDepth == 0 && !strings.EqualFold(tok.text, "VALUES"):
+				// ON CONFLICT, ON DUPLICATE KEY UPDATE or RETURNING after the values
+				valuesDepth = -1
+			}
+		case tok.text == "(":
+			column := ""
+			if i >= 2 && strings.EqualFold(tokens[i-1].text, "IN") && tokens[i-2].kind == tokWord {
+				column = tokens[i-2].text
+			} else if i >= 3 && strings.EqualFold(tokens[i-1].text, "IN") && strings.EqualFold(tokens[i-2].text, "NOT") {
+				column = tokens[i-3].text
+			}
+			inLists = append(inLists, column)
+		case tok.text == ")":
+			if len(inLists) > 0 {
+				inLists = inLists[:len(inLists)-1]
+			}
+		case tok.kind == tokPlaceholder:
+			if i >= 2 && tokens[i-2].kind == tokWord {
+				if _, ok := comparisons[strings.ToUpper(tokens[i-1].text)]; ok {
+					setColumn(tok, tokens[i-2].text)
+					continue
+				}
+			}
+			if len(inLists) > 0 && inLists[len(inLists)-1] != "" {
+				setColumn(tok, inLists[len(inLists)-1])
+			}
+		}
+	}
+	return columns
+}
+
+// insertInto parses INSERT [IGNORE] INTO table (columns) VALUES at i,
+// returns the columns and the position of VALUES, the position is 0 if it's not matched
+func insertInto(tokens []token, i int) ([]string, int) {
+	i++
+	for i < len(tokens) && tokens[i].kind == tokWord && !strings.EqualFold(tokens[i].text, "INTO") {
+		i++ // IGNORE, LOW_PRIORITY etc.
+	}
+	if i >= len(tokens) || !strings.EqualFold(tokens[i].text, "INTO") {
+		return nil, 0
+	}
+	// the table name may be qualified by the schema
+	for i++; i < len(tokens) && (tokens[i].kind == tokWord || tokens[i].text == "."); i++ {
+	}
+	if i >= len(tokens) || tokens[i].text != "(" {
+		return nil, 0
+	}
+
+	var columns []string
+	for i++; i < len(tokens) && tokens[i].text != ")"; i++ {
+		if tokens[i].kind == tokWord {
+			columns = append(columns, tokens[i].text)
+		}
+	}
+	i++
+	if i >= len(tokens) || !(strings.EqualFold(tokens[i].text, "VALUES") || strings.EqualFold(tokens[i].text, "VALUE")) {
+		return nil, 0
+	}
+	return columns, i
+}
+
+// quoteValue returns the SQL literal of the value in the dialect
+func quoteValue(dialect Dialect, v interface{}) string {
+	if valuer, ok := v.(driver.Valuer); ok {
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return "NULL"
+		}
+		value, err := valuer.Value()
+		if err != nil {
+			return fmt.Sprintf("<%v>", err)
+		}
+		v = value
+	}
+
+	switch value := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return quoteString(dialect, value)
+	case []byte:
+		if value == nil {
+			return "NULL"
+		}
+		if dialect == DialectMySQL {
+			return "X'" + hex.EncodeToString(value) + "'"
+		}
+		return `'\x` + hex.EncodeToString(value) + "'"
+	case time.Time:
+		if dialect == DialectMySQL {
+			return "'" + value.Format("2006-01-02 15:04:05.999999") + "'"
+		}
+		return "'" + value.Format("2006-01-02 15:04:05.999999Z07:00") + "'"
+	case bool:
+		if value {
+			return "TRUE"
+		}
+		return "FALSE"
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(value)
+	case float32:
+		return strconv.FormatFloat(float64(value), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	case fmt.Stringer:
+		return quoteString(dialect, value.String())
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return "NULL"
+		}
+		return quoteValue(dialect, rv.Elem().Interface())
+	case reflect.String:
+		return quoteString(dialect, rv.String())
+	case reflect.Bool:
+		return quoteValue(dialect, rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, 64)
+	}
+	return quoteString(dialect, fmt.Sprintf("%+v", v))
+}
+
+func quoteString(dialect Dialect, s string) string {
+	if dialect == DialectMySQL {
+		s = strings.ReplaceAll(s, `\`, `\\`)
+	}
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
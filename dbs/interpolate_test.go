@@ -0,0 +1,57 @@
+package dbs
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestInterpolate(t *testing.T) {
+	at := time.Date(2022, 10, 1, 8, 30, 0, 0, time.UTC)
+	args := []interface{}{1, 2, 3, 4, 5, 6, 7, 8, 9, "ten"}
+	cases := []struct {
+		dialect Dialect
+		query   string
+		args    []interface{}
+		sql     string
+	}{
+		{DialectAuto, "SELECT * FROM t WHERE a = $1 AND j = $10", args,
+			"SELECT * FROM t WHERE a = 1 AND j = 'ten'"},
+		{DialectAuto, "SELECT * FROM users WHERE name = ? AND note = 'what?' -- why?\nAND id = ?", []interface{}{"o'k", 1},
+			"SELECT * FROM users WHERE name = 'o''k' AND note = 'what?' -- why?\nAND id = 1"},
+		{DialectPostgres, "SELECT '$1', $1 /* $2 */, $$ $2 $$, $2", []interface{}{nil, true},
+			"SELECT '$1', NULL /* $2 */, $$ $2 $$, TRUE"},
+		{DialectPostgres, "INSERT INTO files (data, created_at) VALUES ($1, $2)", []interface{}{[]byte{0xde, 0xad}, at},
+			`INSERT INTO files (data, created_at) VALUES ('\xdead', '2022-10-01 08:30:00Z')`},
+		{DialectMySQL, "INSERT INTO files (data, created_at, path) VALUES (?, ?, ?)", []interface{}{[]byte{0xbe, 0xef}, at, `C:\tmp`},
+			`INSERT INTO files (data, created_at, path) VALUES (X'beef', '2022-10-01 08:30:00', 'C:\\tmp')`},
+		{DialectMySQL, `SELECT * FROM t WHERE a = "?" AND b = ?`, []interface{}{(*int)(nil)},
+			`SELECT * FROM t WHERE a = "?" AND b = NULL`},
+		{DialectPostgres, "SELECT * FROM t WHERE a = $1", nil, "SELECT * FROM t WHERE a = $1"},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.sql, Interpolate(c.dialect, c.query, c.args, nil))
+	}
+}
+
+func TestInterpolateRedact(t *testing.T) {
+	sensitive := map[string]struct{}{"password": {}, "token": {}}
+	cases := []struct {
+		query string
+		args  []interface{}
+		sql   string
+	}{
+		{"UPDATE users SET password = $1, name = $2 WHERE u.token = $3", []interface{}{"hash", "name", "t"},
+			"UPDATE users SET password = [***], name = 'name' WHERE u.token = [***]"},
+		{"INSERT INTO users (name, password) VALUES ($1, lower($2)), ($3, $4) ON CONFLICT (name) DO UPDATE SET name = $5",
+			[]interface{}{"a", "p1", "b", "p2", "c"},
+			"INSERT INTO users (name, password) VALUES ('a', lower([***])), ('b', [***]) ON CONFLICT (name) DO UPDATE SET name = 'c'"},
+		{"SELECT * FROM sessions WHERE token IN (?, ?) AND id = ?", []interface{}{"a", "b", 1},
+			"SELECT * FROM sessions WHERE token IN ([***], [***]) AND id = 1"},
+		{"SELECT * FROM users WHERE email = $1", []interface{}{Secret("a@b.c")},
+			"SELECT * FROM users WHERE email = [***]"},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.sql, Interpolate(DialectAuto, c.query, c.args, sensitive))
+	}
+}
@@ -0,0 +1,447 @@
+package dbs
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const defaultMigrationTable = "schema_migrations"
+
+var (
+	ErrNoDownMigration  = errors.New("dbs: no down migration")
+	ErrChecksumMismatch = errors.New("dbs: checksum of the applied migration mismatch")
+	ErrUnknownMigration = errors.New("dbs: unknown migration version")
+	ErrMigrationLocked  = errors.New("dbs: the migration lock is not acquired")
+
+	migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+)
+
+// Migration is a version of the schema, it's read from the files named <version>_<name>.up.sql
+// and <version>_<name>.down.sql, the down file is optional
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationStatus is the status of a migration, the Migration is nil if the applied version has no files
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// Modified the up file has been modified since applied
+	Modified  bool
+	Migration *Migration
+}
+
+type appliedMigration struct {
+	Version   int64     `db:"version"`
+	Name      string    `db:"name"`
+	Checksum  string    `db:"checksum"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+// Migrator applies the migrations to the Database, the applied versions are recorded in the schema table.
+// Only one Migrator migrates the database at a time, it's guarded by an advisory lock of the database.
+//
+// Each migration runs in a transaction, but the DDL of MySQL commits implicitly, so the failed migration
+// may be applied partially on MySQL. The files with multiple statements need multiStatements=true in the DSN of MySQL.
+type Migrator struct {
+	db         *Database
+	table      string
+	migrations []*Migration
+}
+
+type MigratorOption func(m *Migrator)
+
+// WithMigrationTable settings the schema table of the applied versions, default is "schema_migrations"
+func WithMigrationTable(table string) MigratorOption {
+	return func(m *Migrator) {
+		m.table = table
+	}
+}
+
+// NewMigrator reads the migrations from the source, e.g. os.DirFS("migrations"),
+// or fs.Sub of an embed.FS for the migrations embedded in the binary
+func NewMigrator(db *Database, source fs.FS, opts ...MigratorOption) (*Migrator, error) {
+	m := &Migrator{db: db, table: defaultMigrationTable}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	migrations, err := readMigrations(source)
+	if err != nil {
+		return nil, err
+	}
+	m.migrations = migrations
+	return m, nil
+}
+
+func readMigrations(source fs.FS) ([]*Migration, error) {
+	entries, err := fs.ReadDir(source, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[int64]*Migration)
+	for _, entry := range entries {
+		matches := migrationFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("dbs: invalid migration version of [%s]: %w", entry.Name(), err)
+		}
+		content, err := fs.ReadFile(source, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := versions[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: matches[2]}
+			versions[version] = migration
+		} else if migration.Name != matches[2] {
+			return nil, fmt.Errorf("dbs: duplicate migration version %d: %s and %s", version, migration.Name, matches[2])
+		}
+
+		if matches[3] == "up" {
+			sum := sha256.Sum256(content)
+			migration.Up = string(content)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(versions))
+	for _, migration := range versions {
+		if migration.Checksum == "" {
+			return nil, fmt.Errorf("dbs: no up migration of version %d_%s", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Migrations returns the migrations read from the source in the order of version
+func (m *Migrator) Migrations() []*Migration {
+	return m.migrations
+}
+
+// Up applies all the pending migrations in the order of version
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.migrate(ctx, func(applied map[int64]*appliedMigration) error {
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; !ok {
+				if err := m.apply(ctx, migration); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Down rolls back the latest applied migration
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.migrate(ctx, func(applied map[int64]*appliedMigration) error {
+		var latest int64 = -1
+		for version := range applied {
+			if version > latest {
+				latest = version
+			}
+		}
+		if latest < 0 {
+			return nil
+		}
+		return m.rollback(ctx, latest)
+	})
+}
+
+// To migrates the schema to the version, the pending migrations up to the version are applied,
+// and the applied ones after the version are rolled back in the reverse order. The version 0 rolls back all.
+func (m *Migrator) To(ctx context.Context, version int64) error {
+	if version != 0 && m.find(version) == nil {
+		return fmt.Errorf("%w: %d", ErrUnknownMigration, version)
+	}
+
+	return m.migrate(ctx, func(applied map[int64]*appliedMigration) error {
+		versions := make([]int64, 0, len(applied))
+		for v := range applied {
+			if v > version {
+				versions = append(versions, v)
+			}
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+		for _, v := range versions {
+			if err := m.rollback(ctx, v); err != nil {
+				return err
+			}
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+				if err := m.apply(ctx, migration); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Status returns the status of the migrations and the applied versions without files in the order of version,
+// it waits for the migration running by the others
+func (m *Migrator) Status(ctx context.Context) ([]*MigrationStatus, error) {
+	var applied map[int64]*appliedMigration
+	err := m.locked(ctx, func(ctx context.Context) (err error) {
+		applied, err = m.applied(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []*MigrationStatus
+	for _, migration := range m.migrations {
+		status := &MigrationStatus{Version: migration.Version, Name: migration.Name, Migration: migration}
+		if a, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = a.AppliedAt
+			status.Modified = a.Checksum != migration.Checksum
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, a := range applied {
+		statuses = append(statuses, &MigrationStatus{Version: a.Version, Name: a.Name, Applied: true, AppliedAt: a.AppliedAt})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// locked runs fn under the advisory lock, the schema table is created under the lock too,
+// so the concurrent Migrators never race to create it
+func (m *Migrator) locked(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	ctx = ForcePrimary(ctx)
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if uerr := unlock(); uerr != nil && err == nil {
+			err = uerr
+		}
+	}()
+
+	if err = m.createTable(ctx); err != nil {
+		return err
+	}
+	return fn(ctx)
+}
+
+// migrate runs fn with the applied migrations under the advisory lock,
+// ErrChecksumMismatch is returned if any applied migration has been modified
+func (m *Migrator) migrate(ctx context.Context, fn func(applied map[int64]*appliedMigration) error) error {
+	return m.locked(ctx, func(ctx context.Context) error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if a, ok := applied[migration.Version]; ok && a.Checksum != migration.Checksum {
+				return fmt.Errorf("%w: %d_%s", ErrChecksumMismatch, migration.Version, migration.Name)
+			}
+		}
+		return fn(applied)
+	})
+}
+
+func (m *Migrator) apply(ctx context.Context, migration *Migration) error {
+	m.logf("migrate up %d_%s", migration.Version, migration.Name)
+	return m.db.InTx(ctx, nil, func(tx *Tx) error {
+		if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+			return fmt.Errorf("dbs: migrate up %d_%s fail: %w", migration.Version, migration.Name, err)
+		}
+		_, err := tx.ExecContext(ctx, m.db.Rebind("INSERT INTO "+m.table+" (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)"),
+			migration.Version, migration.Name, migration.Checksum, time.Now().UTC())
+		return err
+	})
+}
+
+func (m *Migrator) rollback(ctx context.Context, version int64) error {
+	migration := m.find(version)
+	if migration == nil {
+		return fmt.Errorf("%w: %d", ErrUnknownMigration, version)
+	}
+	if migration.Down == "" {
+		return fmt.Errorf("%w: %d_%s", ErrNoDownMigration, migration.Version, migration.Name)
+	}
+
+	m.logf("migrate down %d_%s", migration.Version, migration.Name)
+	return m.db.InTx(ctx, nil, func(tx *Tx) error {
+		if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+			return fmt.Errorf("dbs: migrate down %d_%s fail: %w", migration.Version, migration.Name, err)
+		}
+		_, err := tx.ExecContext(ctx, m.db.Rebind("DELETE FROM "+m.table+" WHERE version = ?"), migration.Version)
+		return err
+	})
+}
+
+func (m *Migrator) find(version int64) *Migration {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) createTable(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+m.table+
+		" (version BIGINT PRIMARY KEY, name VARCHAR(255) NOT NULL, checksum VARCHAR(64) NOT NULL, applied_at TIMESTAMP NOT NULL)")
+	return err
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int64]*appliedMigration, error) {
+	var rows []*appliedMigration
+	if err := m.db.SelectContext(ctx, &rows, "SELECT version, name, checksum, applied_at FROM "+m.table); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]*appliedMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// lock takes the advisory lock of the schema table on a dedicated connection,
+// the session level lock is released by the returned unlock on the same connection
+func (m *Migrator) lock(ctx context.Context) (unlock func() error, err error) {
+	conn, err := m.db.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var lockSQL, unlockSQL string
+	var key interface{}
+	switch m.db.DriverName() {
+	case "mysql":
+		lockSQL, unlockSQL = "SELECT GET_LOCK(?, -1)", "SELECT RELEASE_LOCK(?)"
+		key = "dbs:migrate:" + m.table
+	default:
+		h := fnv.New64a()
+		_, _ = h.Write([]byte("dbs:migrate:" + m.table))
+		lockSQL, unlockSQL = "SELECT pg_advisory_lock($1)", "SELECT pg_advisory_unlock($1)"
+		key = int64(h.Sum64())
+	}
+
+	// GET_LOCK returns 1 if acquired, 0 or NULL if timed out or failed, pg_advisory_lock returns void
+	var acquired sql.NullInt64
+	if m.db.DriverName() == "mysql" {
+		err = conn.QueryRowContext(ctx, lockSQL, key).Scan(&acquired)
+	} else {
+		_, err = conn.ExecContext(ctx, lockSQL, key)
+		acquired = sql.NullInt64{Int64: 1, Valid: true}
+	}
+	if err == nil && (!acquired.Valid || acquired.Int64 != 1) {
+		err = ErrMigrationLocked
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("dbs: take the migration lock fail: %w", err)
+	}
+	return func() error {
+		_, err := conn.ExecContext(context.Background(), unlockSQL, key)
+		if cerr := conn.Close(); err == nil && cerr != nil && cerr != sql.ErrConnDone {
+			err = cerr
+		}
+		return err
+	}, nil
+}
+
+func (m *Migrator) logf(format string, args ...interface{}) {
+	if m.db.Logger != nil {
+		m.db.Logger.Infof(format, args...)
+	}
+}
+
+// RunMigrateCommand runs the migration command against the database of the dbName in the config,
+// the args is one of "up", "down", "to <version>" and "status", the status is written to w
+func RunMigrateCommand(ctx context.Context, w io.Writer, dbName string, source fs.FS, args ...string) error {
+	if len(args) == 0 {
+		return errors.New("dbs: missing migration command, one of up, down, to <version> and status")
+	}
+
+	db := FetchDB(dbName)
+	if db == nil {
+		return fmt.Errorf("dbs: can't fetch the db with name [%s]", dbName)
+	}
+	defer db.Close()
+
+	m, err := NewMigrator(db, source)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		return m.Up(ctx)
+	case "down":
+		return m.Down(ctx)
+	case "to":
+		if len(args) < 2 {
+			return errors.New("dbs: missing the version to migrate to")
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("dbs: invalid version [%s]: %w", args[1], err)
+		}
+		return m.To(ctx, version)
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			switch {
+			case status.Applied && status.Migration == nil:
+				state = "applied at " + status.AppliedAt.Format(time.RFC3339) + " (missing files)"
+			case status.Modified:
+				state = "applied at " + status.AppliedAt.Format(time.RFC3339) + " (modified)"
+			case status.Applied:
+				state = "applied at " + status.AppliedAt.Format(time.RFC3339)
+			}
+			if _, err = fmt.Fprintf(w, "%d_%s\t%s\n", status.Version, status.Name, state); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("dbs: unknown migration command [%s]", args[0])
+	}
+}
@@ -0,0 +1,116 @@
+package dbs
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var migrationFS = fstest.MapFS{
+	"0001_create_users.up.sql":     {Data: []byte("CREATE TABLE users (id BIGINT PRIMARY KEY)")},
+	"0001_create_users.down.sql":   {Data: []byte("DROP TABLE users")},
+	"0002_add_users_name.up.sql":   {Data: []byte("ALTER TABLE users ADD COLUMN name TEXT")},
+	"0002_add_users_name.down.sql": {Data: []byte("ALTER TABLE users DROP COLUMN name")},
+	"README.md":                    {Data: []byte("migrations")},
+}
+
+func appliedRows(m *Migrator, versions ...int64) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"version", "name", "checksum", "applied_at"})
+	for _, version := range versions {
+		migration := m.find(version)
+		rows.AddRow(migration.Version, migration.Name, migration.Checksum, time.Now())
+	}
+	return rows
+}
+
+func TestReadMigrations(t *testing.T) {
+	migrations, err := readMigrations(migrationFS)
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	require.Equal(t, int64(1), migrations[0].Version)
+	require.Equal(t, "create_users", migrations[0].Name)
+	require.Equal(t, "DROP TABLE users", migrations[0].Down)
+	require.Len(t, migrations[1].Checksum, 64)
+
+	_, err = readMigrations(fstest.MapFS{"0001_users.down.sql": {Data: []byte("DROP TABLE users")}})
+	require.Error(t, err)
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	db, mock, _ := newMockDatabase(t)
+	m, err := NewMigrator(db, migrationFS)
+	require.NoError(t, err)
+
+	mock.ExpectExec("SELECT pg_advisory_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, name, checksum, applied_at FROM schema_migrations").WillReturnRows(appliedRows(m, 1))
+	mock.ExpectBegin()
+	mock.ExpectExec("ALTER TABLE users ADD COLUMN name").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO schema_migrations").
+		WithArgs(int64(2), "add_users_name", m.find(2).Checksum, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, m.Up(context.Background()))
+
+	mock.ExpectExec("SELECT pg_advisory_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version").WillReturnRows(appliedRows(m, 1, 2))
+	mock.ExpectBegin()
+	mock.ExpectExec("ALTER TABLE users DROP COLUMN name").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM schema_migrations").WithArgs(int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, m.Down(context.Background()))
+}
+
+func TestMigrateChecksumMismatch(t *testing.T) {
+	db, mock, _ := newMockDatabase(t)
+	m, err := NewMigrator(db, migrationFS)
+	require.NoError(t, err)
+
+	rows := sqlmock.NewRows([]string{"version", "name", "checksum", "applied_at"}).
+		AddRow(int64(1), "create_users", "modified", time.Now())
+	mock.ExpectExec("SELECT pg_advisory_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version").WillReturnRows(rows)
+	mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+	require.ErrorIs(t, m.To(context.Background(), 2), ErrChecksumMismatch)
+
+	mock.ExpectExec("SELECT pg_advisory_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version").WillReturnRows(appliedRows(m, 1))
+	mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+	statuses, err := m.Status(context.Background())
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	require.True(t, statuses[0].Applied)
+	require.False(t, statuses[0].Modified)
+	require.False(t, statuses[1].Applied)
+
+	require.ErrorIs(t, m.To(context.Background(), 3), ErrUnknownMigration)
+}
+
+func TestMigrateLockMySQL(t *testing.T) {
+	db, mock, _ := newMockDatabase(t)
+	db.DB = sqlx.NewDb(db.DB.DB, "mysql")
+	m, err := NewMigrator(db, migrationFS)
+	require.NoError(t, err)
+
+	// timed out or failed
+	mock.ExpectQuery("SELECT GET_LOCK").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(0))
+	require.ErrorIs(t, m.Up(context.Background()), ErrMigrationLocked)
+	mock.ExpectQuery("SELECT GET_LOCK").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(nil))
+	_, err = m.Status(context.Background())
+	require.ErrorIs(t, err, ErrMigrationLocked)
+
+	mock.ExpectQuery("SELECT GET_LOCK").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version").WillReturnRows(appliedRows(m, 1, 2))
+	mock.ExpectExec("SELECT RELEASE_LOCK").WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, m.Up(context.Background()))
+}
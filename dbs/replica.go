@@ -0,0 +1,181 @@
+package dbs
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultReplicaPingInterval = time.Second * 5
+
+// primaryOnly matches the locking clauses, the sequence functions and the writes in the reads,
+// the statements matched are never routed to the replicas
+var primaryOnly = regexp.MustCompile(`(?i)\bFOR\s+(NO\s+KEY\s+)?UPDATE\b|\bFOR\s+(KEY\s+)?SHARE\b|\bLOCK\s+IN\s+SHARE\s+MODE\b|` +
+	`\b(NEXTVAL|SETVAL|LAST_INSERT_ID|GET_LOCK|PG_ADVISORY_(XACT_)?LOCK)\s*\(|\b(INSERT|UPDATE|DELETE|MERGE|INTO)\b`)
+
+// ReplicaStrategy is how a healthy replica is picked for the reads
+type ReplicaStrategy int
+
+const (
+	RoundRobin ReplicaStrategy = iota
+	// LeastLatency picks the replica with the least latency of the last ping
+	LeastLatency
+)
+
+func parseReplicaStrategy(s string) ReplicaStrategy {
+	if s == "leastLatency" {
+		return LeastLatency
+	}
+	return RoundRobin
+}
+
+type replica struct {
+	*sqlx.DB
+	name    string
+	healthy int32
+	latency int64
+}
+
+func newReplica(name string, db *sqlx.DB) *replica {
+	return &replica{DB: db, name: name, healthy: 1}
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+// ping pings the replica and updates its health, reports whether the health changed
+func (r *replica) ping(timeout time.Duration) (changed bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	err = r.PingContext(ctx)
+	atomic.StoreInt64(&r.latency, int64(time.Since(start)))
+
+	healthy := int32(1)
+	if err != nil {
+		healthy = 0
+	}
+	return atomic.SwapInt32(&r.healthy, healthy) != healthy, err
+}
+
+// replicas routes the reads to the healthy replicas
+type replicas struct {
+	nodes    []*replica
+	strategy ReplicaStrategy
+	next     uint32
+	closing  chan struct{}
+	once     sync.Once
+}
+
+// pick returns a healthy replica, nil if all of them are unhealthy
+func (rs *replicas) pick() *replica {
+	n := len(rs.nodes)
+	if n == 0 {
+		return nil
+	}
+
+	if rs.strategy == LeastLatency {
+		var picked *replica
+		for _, r := range rs.nodes {
+			if r.isHealthy() && (picked == nil || atomic.LoadInt64(&r.latency) < atomic.LoadInt64(&picked.latency)) {
+				picked = r
+			}
+		}
+		return picked
+	}
+
+	start := int(atomic.AddUint32(&rs.next, 1) - 1)
+	for i := 0; i < n; i++ {
+		if r := rs.nodes[(start+i)%n]; r.isHealthy() {
+			return r
+		}
+	}
+	return nil
+}
+
+type primaryKey struct{}
+
+// ForcePrimary returns a copy of ctx which routes the reads to the primary,
+// e.g. reading the rows just written before they're replicated
+func ForcePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func isForcePrimary(ctx context.Context) bool {
+	force, _ := ctx.Value(primaryKey{}).(bool)
+	return force
+}
+
+// reader returns the connection pool for the query of ctx, it's a healthy replica if the query is read-only,
+// unless the primary is forced by ctx or all the replicas are unhealthy
+func (db *Database) reader(ctx context.Context, query string) *sqlx.DB {
+	if db.replicas == nil || ctx == nil || isForcePrimary(ctx) || !readOnly(query) {
+		return db.DB
+	}
+	if r := db.replicas.pick(); r != nil {
+		return r.DB
+	}
+	return db.DB
+}
+
+// readOnly reports whether the query could be routed to the replicas, it's a SELECT or WITH statement
+// without the locking clauses, the sequence functions or the writes, e.g. INSERT ... RETURNING
+func readOnly(query string) bool {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "SELECT", "WITH":
+		return !primaryOnly.MatchString(query)
+	}
+	return false
+}
+
+// pingReplicas pings the replicas every interval until the Database closed,
+// the unhealthy replicas are out of rotation until they respond to the ping again
+func (db *Database) pingReplicas(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-db.replicas.closing:
+			return
+		case <-ticker.C:
+		}
+
+		for _, r := range db.replicas.nodes {
+			changed, err := r.ping(interval)
+			if !changed {
+				continue
+			}
+			if err != nil {
+				db.Logger.Warnf("replica [%s] is out of rotation: %+v", r.name, err)
+			} else {
+				db.Logger.Infof("replica [%s] is back in rotation", r.name)
+			}
+		}
+	}
+}
+
+// closeReplicas stops the pings and closes the replicas
+func (db *Database) closeReplicas() {
+	if db.replicas == nil {
+		return
+	}
+	db.replicas.once.Do(func() {
+		close(db.replicas.closing)
+		for _, r := range db.replicas.nodes {
+			if err := r.Close(); err != nil {
+				db.Logger.Errorf("close replica [%s] fail: %+v", r.name, err)
+			}
+		}
+	})
+}
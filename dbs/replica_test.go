@@ -0,0 +1,116 @@
+package dbs
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func newMockReplica(t *testing.T, name string) (*replica, sqlmock.Sqlmock) {
+	mdb, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, mock.ExpectationsWereMet())
+		_ = mdb.Close()
+	})
+	return newReplica(name, sqlx.NewDb(mdb, "postgres")), mock
+}
+
+func rowsOf(name string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"name"}).AddRow(name)
+}
+
+func TestReplicaRouting(t *testing.T) {
+	db, primary, _ := newMockDatabase(t)
+	r1, m1 := newMockReplica(t, "r1")
+	r2, m2 := newMockReplica(t, "r2")
+	db.replicas = &replicas{nodes: []*replica{r1, r2}, closing: make(chan struct{})}
+
+	m1.ExpectQuery("SELECT name").WillReturnRows(rowsOf("r1"))
+	m2.ExpectQuery("SELECT name").WillReturnRows(rowsOf("r2"))
+	primary.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+	primary.ExpectQuery("INSERT INTO users").WillReturnRows(rowsOf("returning"))
+	primary.ExpectQuery("SELECT name").WillReturnRows(rowsOf("locked"))
+	primary.ExpectQuery("SELECT name").WillReturnRows(rowsOf("primary"))
+	primary.ExpectBegin()
+	primary.ExpectQuery("SELECT name").WillReturnRows(rowsOf("tx"))
+	primary.ExpectCommit()
+
+	var name string
+	ctx := context.Background()
+	require.NoError(t, db.GetContext(ctx, &name, "SELECT name FROM users"))
+	require.Equal(t, "r1", name)
+	require.NoError(t, db.GetContext(ctx, &name, "SELECT name FROM users"))
+	require.Equal(t, "r2", name)
+
+	_, err := db.ExecContext(ctx, "UPDATE users SET name = 'primary'")
+	require.NoError(t, err)
+	require.NoError(t, db.QueryRowxContext(ctx, "INSERT INTO users (name) VALUES ('a') RETURNING name").Scan(&name))
+	require.Equal(t, "returning", name)
+	require.NoError(t, db.GetContext(ctx, &name, "SELECT name FROM users WHERE id = 1 FOR UPDATE"))
+	require.Equal(t, "locked", name)
+	require.NoError(t, db.GetContext(ForcePrimary(ctx), &name, "SELECT name FROM users"))
+	require.Equal(t, "primary", name)
+
+	err = db.InTx(ctx, nil, func(tx *Tx) error {
+		return db.GetContext(tx.Context(), &name, "SELECT name FROM users")
+	})
+	require.NoError(t, err)
+	require.Equal(t, "tx", name)
+}
+
+func TestReadOnly(t *testing.T) {
+	for query, expected := range map[string]bool{
+		"SELECT name FROM users":                                     true,
+		"  with t AS (SELECT 1) SELECT * FROM t":                     true,
+		"SELECT * FROM users WHERE id = $1 FOR UPDATE":               false,
+		"SELECT * FROM users FOR NO KEY UPDATE SKIP LOCKED":          false,
+		"SELECT * FROM users LOCK IN SHARE MODE":                     false,
+		"SELECT nextval('users_id_seq')":                             false,
+		"WITH t AS (DELETE FROM users RETURNING id) SELECT * FROM t": false,
+		"SELECT * INTO backup FROM users":                            false,
+		"INSERT INTO users (name) VALUES ($1) RETURNING id":          false,
+		"UPDATE users SET name = $1":                                 false,
+		"":                                                           false,
+	} {
+		require.Equal(t, expected, readOnly(query), query)
+	}
+}
+
+func TestReplicaHealth(t *testing.T) {
+	db, primary, _ := newMockDatabase(t)
+	r1, m1 := newMockReplica(t, "r1")
+	r2, m2 := newMockReplica(t, "r2")
+	db.replicas = &replicas{nodes: []*replica{r1, r2}, strategy: LeastLatency, closing: make(chan struct{})}
+
+	// r1 is out of rotation after the failed ping
+	m1.ExpectPing().WillReturnError(errors.New("connection refused"))
+	m2.ExpectPing().WillDelayFor(time.Millisecond)
+	changed, err := r1.ping(time.Second)
+	require.True(t, changed)
+	require.Error(t, err)
+	_, err = r2.ping(time.Second)
+	require.NoError(t, err)
+	require.Same(t, r2.DB, db.reader(context.Background(), "SELECT 1"))
+
+	// the primary is used when all the replicas are unhealthy
+	m2.ExpectPing().WillReturnError(errors.New("connection refused"))
+	_, _ = r2.ping(time.Second)
+	primary.ExpectQuery("SELECT name").WillReturnRows(rowsOf("primary"))
+	var name string
+	require.NoError(t, db.Get(&name, "SELECT name FROM users"))
+	require.Equal(t, "primary", name)
+
+	// the least latency one is picked after both back in rotation
+	m1.ExpectPing()
+	m2.ExpectPing().WillDelayFor(time.Millisecond * 20)
+	changed, err = r1.ping(time.Second)
+	require.True(t, changed)
+	require.NoError(t, err)
+	_, _ = r2.ping(time.Second)
+	require.Same(t, r1.DB, db.reader(context.Background(), "SELECT 1"))
+}
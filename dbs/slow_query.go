@@ -0,0 +1,282 @@
+package dbs
+
+import (
+	"context"
+	"github.com/transerver/commons/logger"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	defaultStatsSamples  = 1000
+	defaultExplainWorker = 2
+	explainTimeout       = time.Second * 5
+)
+
+var fingerprintInList = regexp.MustCompile(`\(\s*\?(\s*,\s*\?)*\s*\)`)
+
+// QueryStats is the statistics of the statements with the same fingerprint,
+// the percentiles are calculated from the latest samples
+type QueryStats struct {
+	Fingerprint string        `json:"fingerprint"`
+	Count       int64         `json:"count"`
+	Slow        int64         `json:"slow"`
+	Errors      int64         `json:"errors"`
+	P50         time.Duration `json:"p50"`
+	P99         time.Duration `json:"p99"`
+	Max         time.Duration `json:"max"`
+}
+
+type queryStats struct {
+	count, slow, errors int64
+	max                 time.Duration
+	samples             []time.Duration
+	next                int
+}
+
+// SlowQueryHook is the DatabaseLoggerHook with the slow query detection, the statements took longer than
+// the threshold are logged at warn level, with the plan if the explain is enabled.
+// The statistics of the statements are kept by fingerprint, which is the query with the literals and args replaced.
+type SlowQueryHook struct {
+	DatabaseLoggerHook
+	Threshold time.Duration
+
+	db       *Database
+	explains chan struct{}
+	samples  int
+	stats    map[string]*queryStats
+	mutex    sync.Mutex
+}
+
+type SlowQueryOption func(h *SlowQueryHook)
+
+// WithExplain runs EXPLAIN (FORMAT JSON) of the slow queries on the db in the background,
+// at most workers explains are running at a time, the slow queries beyond are logged without the plan
+func WithExplain(db *Database, workers int) SlowQueryOption {
+	return func(h *SlowQueryHook) {
+		if workers <= 0 {
+			workers = defaultExplainWorker
+		}
+		h.db = db
+		h.explains = make(chan struct{}, workers)
+	}
+}
+
+// WithStatsSamples settings how many latest samples of each fingerprint are kept for the percentiles, default is 1000
+func WithStatsSamples(samples int) SlowQueryOption {
+	return func(h *SlowQueryHook) {
+		h.samples = samples
+	}
+}
+
+func NewSlowQueryHook(logger *logger.Logger, threshold time.Duration, opts ...SlowQueryOption) *SlowQueryHook {
+	h := &SlowQueryHook{
+		DatabaseLoggerHook: DatabaseLoggerHook{Logger: logger},
+		Threshold:          threshold,
+		samples:            defaultStatsSamples,
+		stats:              make(map[string]*queryStats),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func (h *SlowQueryHook) After(ctx context.Context, query string, args ...interface{}) (context.Context, error) {
+	ctx, err := h.DatabaseLoggerHook.After(ctx, query, args...)
+	if startTime, ok := ctx.Value("startTime").(time.Time); ok {
+		took := time.Since(startTime)
+		if h.record(query, took, false) {
+			h.slow(query, took, args...)
+		}
+	}
+	return ctx, err
+}
+
+func (h *SlowQueryHook) OnError(ctx context.Context, err error, query string, args ...interface{}) {
+	h.DatabaseLoggerHook.OnError(ctx, err, query, args...)
+	if startTime, ok := ctx.Value("startTime").(time.Time); ok {
+		h.record(query, time.Since(startTime), true)
+	}
+}
+
+// Stats returns the statistics of the fingerprints in the descending order of the count
+func (h *SlowQueryHook) Stats() []QueryStats {
+	h.mutex.Lock()
+	stats := make([]QueryStats, 0, len(h.stats))
+	for fingerprint, s := range h.stats {
+		samples := append([]time.Duration(nil), s.samples...)
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		stats = append(stats, QueryStats{
+			Fingerprint: fingerprint,
+			Count:       s.count,
+			Slow:        s.slow,
+			Errors:      s.errors,
+			P50:         percentile(samples, 50),
+			P99:         percentile(samples, 99),
+			Max:         s.max,
+		})
+	}
+	h.mutex.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Count != stats[j].Count {
+			return stats[i].Count > stats[j].Count
+		}
+		return stats[i].Fingerprint < stats[j].Fingerprint
+	})
+	return stats
+}
+
+func (h *SlowQueryHook) ResetStats() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.stats = make(map[string]*queryStats)
+}
+
+// record records the took of the query, reports whether the query is slow
+func (h *SlowQueryHook) record(query string, took time.Duration, failed bool) bool {
+	fingerprint := Fingerprint(query)
+	slow := h.Threshold > 0 && took >= h.Threshold
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s, ok := h.stats[fingerprint]
+	if !ok {
+		s = &queryStats{}
+		h.stats[fingerprint] = s
+	}
+	s.count++
+	if failed {
+		s.errors++
+	}
+	if slow {
+		s.slow++
+	}
+	if took > s.max {
+		s.max = took
+	}
+	if len(s.samples) < h.samples {
+		s.samples = append(s.samples, took)
+	} else if h.samples > 0 {
+		s.samples[s.next] = took
+		s.next = (s.next + 1) % h.samples
+	}
+	return slow
+}
+
+// slow logs the slow query, with the plan if the explain is enabled
+func (h *SlowQueryHook) slow(query string, took time.Duration, args ...interface{}) {
+	sql := h.format(query, args...)
+	if h.db == nil || !explainable(query) {
+		h.Logger.Warnf("SLOW SQL: %s, Took: %s", sql, took)
+		return
+	}
+
+	select {
+	case h.explains <- struct{}{}:
+	default:
+		h.Logger.Warnf("SLOW SQL: %s, Took: %s, Plan: too many explains running", sql, took)
+		return
+	}
+
+	go func() {
+		defer func() { <-h.explains }()
+		plan, err := h.explain(query, args...)
+		if err != nil {
+			h.Logger.Warnf("SLOW SQL: %s, Took: %s, Plan: explain fail: %+v", sql, took, err)
+			return
+		}
+		h.Logger.Warnf("SLOW SQL: %s, Took: %s, Plan: %s", sql, took, plan)
+	}()
+}
+
+// explain returns the plan of the query in JSON, it's executed on the pool directly to skip the hooks
+func (h *SlowQueryHook) explain(query string, args ...interface{}) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), explainTimeout)
+	defer cancel()
+
+	prefix := "EXPLAIN (FORMAT JSON) "
+	if h.db.DriverName() == "mysql" {
+		prefix = "EXPLAIN FORMAT=JSON "
+	}
+
+	var plan string
+	err := h.db.DB.QueryRowxContext(ctx, prefix+query, args...).Scan(&plan)
+	return plan, err
+}
+
+// explainable reports whether the query is a statement could be explained
+func explainable(query string) bool {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "SELECT", "INSERT", "UPDATE", "DELETE", "WITH":
+		return true
+	}
+	return false
+}
+
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// Fingerprint returns the query with the literals and placeholders replaced with ?,
+// the lists of them in parentheses replaced with (?) and the whitespaces collapsed,
+// so the queries differ only in the values have the same fingerprint
+func Fingerprint(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+	runes := []rune(query)
+	space := false
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			space = b.Len() > 0
+			continue
+		case r == '\'':
+			// string literal, the quote is escaped by doubled quotes
+			for i++; i < len(runes); i++ {
+				if runes[i] == '\'' {
+					if i+1 < len(runes) && runes[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			r = '?'
+		case r == '$' && i+1 < len(runes) && unicode.IsDigit(runes[i+1]),
+			unicode.IsDigit(r) && (i == 0 || !isIdentRune(runes[i-1])):
+			for i+1 < len(runes) && (unicode.IsDigit(runes[i+1]) || runes[i+1] == '.') {
+				i++
+			}
+			r = '?'
+		}
+
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	return fingerprintInList.ReplaceAllString(b.String(), "(?)")
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
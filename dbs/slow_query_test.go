@@ -0,0 +1,49 @@
+package dbs
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestFingerprint(t *testing.T) {
+	cases := map[string]string{
+		"SELECT * FROM users WHERE id = $1":                       "SELECT * FROM users WHERE id = ?",
+		"SELECT *\n  FROM users\tWHERE id = 42 AND name = 'o''k'": "SELECT * FROM users WHERE id = ? AND name = ?",
+		"SELECT * FROM t1 WHERE id IN ($1, $2, $3)":               "SELECT * FROM t1 WHERE id IN (?)",
+		"SELECT * FROM users WHERE id IN (?,?) LIMIT 10":          "SELECT * FROM users WHERE id IN (?) LIMIT ?",
+		"UPDATE users SET score = 1.5 WHERE id = ?":               "UPDATE users SET score = ? WHERE id = ?",
+	}
+	for query, fingerprint := range cases {
+		require.Equal(t, fingerprint, Fingerprint(query))
+	}
+}
+
+func TestSlowQueryHook(t *testing.T) {
+	db, mock, _ := newMockDatabase(t)
+	hook := db.SetSlowQueryHook(time.Millisecond*10, true)
+
+	mock.ExpectQuery("SELECT name FROM users").WithArgs(1).WillReturnRows(rowsOf("fast"))
+	mock.ExpectQuery("SELECT name FROM users").WithArgs(2).WillDelayFor(time.Millisecond * 20).WillReturnRows(rowsOf("slow"))
+	mock.ExpectQuery("EXPLAIN \\(FORMAT JSON\\) SELECT name FROM users").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow(`[{"Plan": {"Node Type": "Seq Scan"}}]`))
+
+	var name string
+	require.NoError(t, db.Get(&name, "SELECT name FROM users WHERE id = $1", 1))
+	require.NoError(t, db.Get(&name, "SELECT name FROM users WHERE id = $1", 2))
+	require.Eventually(t, func() bool {
+		return mock.ExpectationsWereMet() == nil
+	}, time.Second, time.Millisecond*10)
+
+	stats := hook.Stats()
+	require.Len(t, stats, 1)
+	require.Equal(t, "SELECT name FROM users WHERE id = ?", stats[0].Fingerprint)
+	require.Equal(t, int64(2), stats[0].Count)
+	require.Equal(t, int64(1), stats[0].Slow)
+	require.GreaterOrEqual(t, stats[0].P99, time.Millisecond*20)
+	require.Less(t, stats[0].P50, time.Millisecond*10)
+
+	hook.ResetStats()
+	require.Empty(t, hook.Stats())
+}
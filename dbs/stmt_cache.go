@@ -0,0 +1,193 @@
+package dbs
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"strings"
+	"sync"
+)
+
+type stmtKey struct {
+	pool  *sqlx.DB
+	query string
+}
+
+type stmtEntry struct {
+	key  stmtKey
+	stmt *sqlx.Stmt
+	// refs is the number of the goroutines using the statement, the statement removed from the cache
+	// is closed after the last one released it
+	refs    int
+	removed bool
+}
+
+// stmtCache caches the prepared statements by the query of each connection pool,
+// the least recently used ones are closed when the cache is full
+type stmtCache struct {
+	size    int
+	entries map[stmtKey]*list.Element
+	lru     *list.List
+	mutex   sync.Mutex
+}
+
+func newStmtCache(size int) *stmtCache {
+	return &stmtCache{size: size, entries: make(map[stmtKey]*list.Element), lru: list.New()}
+}
+
+// get returns the cached statement of the query, the query is prepared if it's not cached.
+// The statement is held until it's released by the release
+func (c *stmtCache) get(ctx context.Context, pool *sqlx.DB, query string) (*stmtEntry, error) {
+	key := stmtKey{pool: pool, query: query}
+	c.mutex.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		entry := e.Value.(*stmtEntry)
+		entry.refs++
+		c.mutex.Unlock()
+		return entry, nil
+	}
+	c.mutex.Unlock()
+
+	stmt, err := pool.PreparexContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	if e, ok := c.entries[key]; ok {
+		// prepared by another goroutine at the same time
+		c.lru.MoveToFront(e)
+		entry := e.Value.(*stmtEntry)
+		entry.refs++
+		c.mutex.Unlock()
+		_ = stmt.Close()
+		return entry, nil
+	}
+
+	entry := &stmtEntry{key: key, stmt: stmt, refs: 1}
+	c.entries[key] = c.lru.PushFront(entry)
+	var closing *sqlx.Stmt
+	if c.lru.Len() > c.size {
+		closing = c.remove(c.lru.Back())
+	}
+	c.mutex.Unlock()
+
+	closeStmt(closing)
+	return entry, nil
+}
+
+// release releases the statement held by the get, it's closed if it's the last holder of the removed statement
+func (c *stmtCache) release(entry *stmtEntry) {
+	c.mutex.Lock()
+	entry.refs--
+	var closing *sqlx.Stmt
+	if entry.removed && entry.refs == 0 {
+		closing = entry.stmt
+	}
+	c.mutex.Unlock()
+	closeStmt(closing)
+}
+
+// evict removes the statement from the cache, it's closed after all the holders released it
+func (c *stmtCache) evict(entry *stmtEntry) {
+	c.mutex.Lock()
+	var closing *sqlx.Stmt
+	if e, ok := c.entries[entry.key]; ok && e.Value.(*stmtEntry) == entry {
+		closing = c.remove(e)
+	}
+	c.mutex.Unlock()
+	closeStmt(closing)
+}
+
+// remove removes the element from the cache under the lock,
+// returns the statement to close if it isn't held by anyone
+func (c *stmtCache) remove(e *list.Element) *sqlx.Stmt {
+	entry := c.lru.Remove(e).(*stmtEntry)
+	delete(c.entries, entry.key)
+	entry.removed = true
+	if entry.refs == 0 {
+		return entry.stmt
+	}
+	return nil
+}
+
+func (c *stmtCache) len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lru.Len()
+}
+
+// close closes all the cached statements, the ones in use are closed after released
+func (c *stmtCache) close() {
+	c.mutex.Lock()
+	var closing []*sqlx.Stmt
+	for c.lru.Len() > 0 {
+		if stmt := c.remove(c.lru.Back()); stmt != nil {
+			closing = append(closing, stmt)
+		}
+	}
+	c.mutex.Unlock()
+
+	for _, stmt := range closing {
+		closeStmt(stmt)
+	}
+}
+
+// closeStmt closes the statement if it's not nil, the rows of the statement still open
+// are kept valid, the statement is finalized after they are closed
+func closeStmt(stmt *sqlx.Stmt) {
+	if stmt != nil {
+		_ = stmt.Close()
+	}
+}
+
+// isStaleStmt reports whether the prepared statement is invalidated by the schema changes,
+// it succeeds after prepared again
+func isStaleStmt(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "0A000" && strings.Contains(pqErr.Message, "cached plan must not change result type")
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1615 // ER_NEED_REPREPARE
+	}
+	return false
+}
+
+// withStmt runs fn with the cached statement of the query if the statement cache is enabled, otherwise with nil.
+// fn is also run with nil if the statement failed to prepare, so the query still runs once on the pool.
+// The statement is evicted if it's invalidated by a broken connection or the schema changes,
+// fn is retried once with the statement prepared again for the latter.
+func (db *Database) withStmt(ctx context.Context, pool *sqlx.DB, query string, fn func(stmt *sqlx.Stmt) error) error {
+	if db.stmts == nil {
+		return fn(nil)
+	}
+
+	for retried := false; ; retried = true {
+		entry, err := db.stmts.get(ctx, pool, query)
+		if err != nil {
+			db.Logger.Warnf("prepare statement fail, run on the pool: %+v", err)
+			return fn(nil)
+		}
+
+		err = fn(entry.stmt)
+		switch {
+		case isStaleStmt(err):
+			db.stmts.evict(entry)
+			db.stmts.release(entry)
+			if !retried {
+				continue
+			}
+			return err
+		case IsConnectionError(err):
+			db.stmts.evict(entry)
+		}
+		db.stmts.release(entry)
+		return err
+	}
+}
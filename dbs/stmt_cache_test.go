@@ -0,0 +1,170 @@
+package dbs
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestStmtCache(t *testing.T) {
+	db, mock, hook := newMockDatabase(t)
+	WithStmtCache(1)(db)
+
+	users := "SELECT name FROM users WHERE id = $1"
+	mock.ExpectPrepare("SELECT name FROM users")
+	mock.ExpectQuery("SELECT name FROM users").WithArgs(1).WillReturnRows(rowsOf("a"))
+	mock.ExpectQuery("SELECT name FROM users").WithArgs(2).WillReturnRows(rowsOf("b"))
+	mock.ExpectPrepare("UPDATE users")
+	mock.ExpectExec("UPDATE users").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectPrepare("SELECT name FROM users")
+	mock.ExpectQuery("SELECT name FROM users").WithArgs(3).WillReturnRows(rowsOf("c"))
+
+	var name string
+	require.NoError(t, db.Get(&name, users, 1))
+	require.NoError(t, db.Get(&name, users, 2))
+	require.Equal(t, "b", name)
+	require.Equal(t, 1, db.stmts.len())
+
+	// the statement of users is evicted by the update
+	_, err := db.Exec("UPDATE users SET active = true WHERE id = $1", 1)
+	require.NoError(t, err)
+	require.NoError(t, db.Get(&name, users, 3))
+	require.Equal(t, "c", name)
+	require.Len(t, hook.queries, 4)
+}
+
+func TestStmtCacheStale(t *testing.T) {
+	db, mock, hook := newMockDatabase(t)
+	WithStmtCache(8)(db)
+
+	stale := &pq.Error{Code: "0A000", Message: "cached plan must not change result type"}
+	mock.ExpectPrepare("SELECT \\* FROM users")
+	mock.ExpectQuery("SELECT \\* FROM users").WillReturnError(stale)
+	mock.ExpectPrepare("SELECT \\* FROM users")
+	mock.ExpectQuery("SELECT \\* FROM users").WillReturnRows(rowsOf("name"))
+
+	var names []string
+	require.NoError(t, db.SelectContext(context.Background(), &names, "SELECT * FROM users"))
+	require.Equal(t, []string{"name"}, names)
+	require.Empty(t, hook.errors)
+	require.Equal(t, 1, db.stmts.len())
+}
+
+func TestStmtCachePrepareError(t *testing.T) {
+	db, mock, hook := newMockDatabase(t)
+	WithStmtCache(8)(db)
+
+	mock.ExpectPrepare("SELECT \\* FROM users").WillReturnError(sql.ErrConnDone)
+	mock.ExpectQuery("SELECT \\* FROM users").WillReturnError(sql.ErrConnDone)
+
+	rows, err := db.QueryContext(context.Background(), "SELECT * FROM users")
+	require.ErrorIs(t, err, sql.ErrConnDone)
+	require.Nil(t, rows)
+	require.Len(t, hook.errors, 1)
+	require.Zero(t, db.stmts.len())
+
+	// the query runs once on the pool after failed to prepare
+	mock.ExpectPrepare("SELECT name FROM users").WillReturnError(sql.ErrConnDone)
+	mock.ExpectQuery("SELECT name FROM users").WillReturnRows(rowsOf("pool"))
+	var name string
+	require.NoError(t, db.QueryRowxContext(context.Background(), "SELECT name FROM users").Scan(&name))
+	require.Equal(t, "pool", name)
+	require.Len(t, hook.queries, 2)
+	require.Len(t, hook.errors, 1)
+}
+
+// stubDriver is a driver prepares any query, the queries return a row of the query
+type stubDriver struct{}
+
+type stubConn struct{}
+
+type stubStmt struct{ query string }
+
+type stubRows struct {
+	query string
+	done  bool
+}
+
+func (stubDriver) Open(string) (driver.Conn, error) { return stubConn{}, nil }
+
+func (stubConn) Prepare(query string) (driver.Stmt, error) { return &stubStmt{query: query}, nil }
+func (stubConn) Close() error                              { return nil }
+func (stubConn) Begin() (driver.Tx, error)                 { return nil, driver.ErrSkip }
+
+func (s *stubStmt) Close() error  { return nil }
+func (s *stubStmt) NumInput() int { return -1 }
+func (s *stubStmt) Exec([]driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+func (s *stubStmt) Query([]driver.Value) (driver.Rows, error) {
+	return &stubRows{query: s.query}, nil
+}
+
+func (r *stubRows) Columns() []string { return []string{"query"} }
+func (r *stubRows) Close() error      { return nil }
+func (r *stubRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.query
+	return nil
+}
+
+func TestStmtCacheConcurrentEviction(t *testing.T) {
+	db := NewDatabase(WithStmtCache(2))
+	db.DB = sqlx.NewDb(sql.OpenDB(stubConnector{}), "postgres")
+	defer db.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				// more queries than the cache size, the statements are evicted while in use
+				query := fmt.Sprintf("SELECT %d", (i+j)%5)
+				var got string
+				if err := db.GetContext(context.Background(), &got, query); err != nil {
+					errs <- err
+					return
+				}
+				if got != query {
+					errs <- fmt.Errorf("got %s, want %s", got, query)
+					return
+				}
+				rows, err := db.QueryxContext(context.Background(), query)
+				if err != nil {
+					errs <- err
+					return
+				}
+				for rows.Next() {
+				}
+				if err = rows.Close(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.LessOrEqual(t, db.stmts.len(), 2)
+}
+
+type stubConnector struct{}
+
+func (stubConnector) Connect(context.Context) (driver.Conn, error) { return stubConn{}, nil }
+func (stubConnector) Driver() driver.Driver                        { return stubDriver{} }
@@ -0,0 +1,309 @@
+package dbs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+const (
+	sqlBegin    = "BEGIN"
+	sqlCommit   = "COMMIT"
+	sqlRollback = "ROLLBACK"
+)
+
+// Tx is a transaction of the Database, the statements are executed with the DatabaseHook of the Database.
+// The hook sees the BEGIN, COMMIT and ROLLBACK of the transaction as statements too.
+// The sqlx.Tx isn't exposed, so no statement of the transaction bypasses the hook.
+type Tx struct {
+	tx  *sqlx.Tx
+	db  *Database
+	ctx context.Context
+
+	// savepoints the number of savepoints created, it names the next savepoint
+	savepoints int
+}
+
+type txKey struct{}
+
+// ContextWithTx returns a copy of ctx which carries the tx, the methods of the
+// Database with the returned context are executed within the tx
+func ContextWithTx(ctx context.Context, tx *Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// TxFromContext returns the Tx carried by ctx, nil if ctx is not in a transaction
+func TxFromContext(ctx context.Context) *Tx {
+	if ctx == nil {
+		return nil
+	}
+	tx, _ := ctx.Value(txKey{}).(*Tx)
+	return tx
+}
+
+// txOf returns the Tx of the Database carried by ctx
+func (db *Database) txOf(ctx context.Context) *Tx {
+	if tx := TxFromContext(ctx); tx != nil && tx.db == db {
+		return tx
+	}
+	return nil
+}
+
+// BeginTxx begins a transaction, the ctx is used until the transaction is committed or rolled back
+func (db *Database) BeginTxx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	hctx := db.before(ctx, sqlBegin)
+	tx, err := db.DB.BeginTxx(ctx, opts)
+	if err = db.after(hctx, err, sqlBegin); err != nil {
+		if tx != nil {
+			_ = tx.Rollback()
+		}
+		return nil, err
+	}
+	t := &Tx{tx: tx, db: db}
+	t.ctx = ContextWithTx(ctx, t)
+	return t, nil
+}
+
+func (db *Database) Beginx() (*Tx, error) {
+	return db.BeginTxx(context.Background(), nil)
+}
+
+// InTx runs fn in a transaction, the transaction is committed if fn returns nil,
+// otherwise it's rolled back. It's rolled back too if fn panics, and the panic is propagated.
+// The Context of the tx carries it, so the methods of the Database called with it join the transaction.
+//
+// If ctx is already in a transaction of the Database, fn runs in a SAVEPOINT of it and the opts is ignored,
+// the savepoint is released if fn returns nil, otherwise only the changes since the savepoint are rolled back.
+//
+// The transaction is retried with backoff when it fails with a retryable error, see WithTxRetry and IsRetryable,
+// so fn may run more than once and shouldn't have side effects out of the transaction.
+// The nested ones are never retried, the retryable error aborts the whole transaction.
+func (db *Database) InTx(ctx context.Context, opts *sql.TxOptions, fn func(tx *Tx) error) (err error) {
+	if tx := db.txOf(ctx); tx != nil {
+		return tx.inSavepoint(ctx, fn)
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	for attempts := 1; ; attempts++ {
+		err = db.inTx(ctx, opts, fn)
+		if err == nil || attempts >= db.txAttempts || !IsRetryable(err) {
+			return err
+		}
+
+		delay := db.txRetryDelay(attempts)
+		if db.Logger != nil {
+			db.Logger.Warnf("transaction fail with retryable error, retry after %s: %+v", delay, err)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// txRetryDelay returns the delay of the nth retry, it's a random duration between the half and the full backoff
+func (db *Database) txRetryDelay(attempts int) time.Duration {
+	delay := db.txBackoff
+	for i := 1; i < attempts && delay < db.txMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > db.txMaxBackoff {
+		delay = db.txMaxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (db *Database) inTx(ctx context.Context, opts *sql.TxOptions, fn func(tx *Tx) error) (err error) {
+	tx, err := db.BeginTxx(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			_ = tx.Rollback()
+			panic(r)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		if rerr := tx.Rollback(); rerr != nil {
+			return fmt.Errorf("%w, rollback fail: %v", err, rerr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// inSavepoint runs fn in a new savepoint of the transaction
+func (tx *Tx) inSavepoint(ctx context.Context, fn func(tx *Tx) error) (err error) {
+	tx.savepoints++
+	name := "sp_" + strconv.Itoa(tx.savepoints)
+	if _, err = tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			_, _ = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+			panic(r)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		if _, rerr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rerr != nil {
+			return fmt.Errorf("%w, rollback to savepoint fail: %v", err, rerr)
+		}
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	return err
+}
+
+// Context returns the context of the transaction which carries it
+func (tx *Tx) Context() context.Context {
+	return tx.ctx
+}
+
+func (tx *Tx) Commit() error {
+	ctx := tx.db.before(tx.ctx, sqlCommit)
+	err := tx.tx.Commit()
+	return tx.db.after(ctx, err, sqlCommit)
+}
+
+func (tx *Tx) Rollback() error {
+	ctx := tx.db.before(tx.ctx, sqlRollback)
+	err := tx.tx.Rollback()
+	return tx.db.after(ctx, err, sqlRollback)
+}
+
+func (tx *Tx) DriverName() string {
+	return tx.tx.DriverName()
+}
+
+func (tx *Tx) Rebind(query string) string {
+	return tx.tx.Rebind(query)
+}
+
+func (tx *Tx) BindNamed(query string, arg interface{}) (string, []interface{}, error) {
+	return tx.tx.BindNamed(query, arg)
+}
+
+// NamedQueryContext within the transaction.
+// Any named placeholder parameters are replaced with fields from arg.
+func (tx *Tx) NamedQueryContext(ctx context.Context, query string, arg interface{}) (*sqlx.Rows, error) {
+	querySQL, args, err := tx.tx.BindNamed(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	return tx.QueryxContext(ctx, querySQL, args...)
+}
+
+func (tx *Tx) NamedQuery(query string, arg interface{}) (*sqlx.Rows, error) {
+	return tx.NamedQueryContext(tx.ctx, query, arg)
+}
+
+// NamedExecContext within the transaction.
+// Any named placeholder parameters are replaced with fields from arg.
+func (tx *Tx) NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error) {
+	querySQL, args, err := tx.tx.BindNamed(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	return tx.ExecContext(ctx, querySQL, args...)
+}
+
+func (tx *Tx) NamedExec(query string, arg interface{}) (sql.Result, error) {
+	return tx.NamedExecContext(tx.ctx, query, arg)
+}
+
+func (tx *Tx) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx = tx.db.before(ctx, query, args...)
+	err := tx.tx.SelectContext(ctx, dest, query, args...)
+	return tx.db.after(ctx, err, query, args...)
+}
+
+func (tx *Tx) Select(dest interface{}, query string, args ...interface{}) error {
+	return tx.SelectContext(tx.ctx, dest, query, args...)
+}
+
+func (tx *Tx) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx = tx.db.before(ctx, query, args...)
+	err := tx.tx.GetContext(ctx, dest, query, args...)
+	return tx.db.after(ctx, err, query, args...)
+}
+
+func (tx *Tx) Get(dest interface{}, query string, args ...interface{}) error {
+	return tx.GetContext(tx.ctx, dest, query, args...)
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx = tx.db.before(ctx, query, args...)
+	result, err := tx.tx.ExecContext(ctx, query, args...)
+	err = tx.db.after(ctx, err, query, args...)
+	return result, err
+}
+
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.ExecContext(tx.ctx, query, args...)
+}
+
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx = tx.db.before(ctx, query, args...)
+	rows, err := tx.tx.QueryContext(ctx, query, args...)
+	err = tx.db.after(ctx, err, query, args...)
+	return rows, err
+}
+
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.QueryContext(tx.ctx, query, args...)
+}
+
+func (tx *Tx) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	ctx = tx.db.before(ctx, query, args...)
+	rows, err := tx.tx.QueryxContext(ctx, query, args...)
+	err = tx.db.after(ctx, err, query, args...)
+	return rows, err
+}
+
+func (tx *Tx) Queryx(query string, args ...interface{}) (*sqlx.Rows, error) {
+	return tx.QueryxContext(tx.ctx, query, args...)
+}
+
+func (tx *Tx) QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row {
+	ctx = tx.db.before(ctx, query, args...)
+	row := tx.tx.QueryRowxContext(ctx, query, args...)
+	_ = tx.db.after(ctx, row.Err(), query, args...)
+	return row
+}
+
+func (tx *Tx) QueryRowx(query string, args ...interface{}) *sqlx.Row {
+	return tx.QueryRowxContext(tx.ctx, query, args...)
+}
+
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx = tx.db.before(ctx, query, args...)
+	row := tx.tx.QueryRowContext(ctx, query, args...)
+	_ = tx.db.after(ctx, row.Err(), query, args...)
+	return row
+}
+
+func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.QueryRowContext(tx.ctx, query, args...)
+}
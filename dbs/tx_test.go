@@ -0,0 +1,173 @@
+package dbs
+
+import (
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+	"github.com/transerver/commons/logger"
+	"testing"
+)
+
+// recordHook records the statements seen by the hook
+type recordHook struct {
+	DatabaseLoggerHook
+	queries []string
+	errors  []error
+}
+
+func (h *recordHook) After(ctx context.Context, query string, args ...interface{}) (context.Context, error) {
+	h.queries = append(h.queries, query)
+	return h.DatabaseLoggerHook.After(ctx, query, args...)
+}
+
+func (h *recordHook) OnError(ctx context.Context, err error, query string, args ...interface{}) {
+	h.queries = append(h.queries, query)
+	h.errors = append(h.errors, err)
+	h.DatabaseLoggerHook.OnError(ctx, err, query, args...)
+}
+
+func newMockDatabase(t *testing.T) (*Database, sqlmock.Sqlmock, *recordHook) {
+	mdb, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, mock.ExpectationsWereMet())
+		_ = mdb.Close()
+	})
+
+	hook := &recordHook{DatabaseLoggerHook: DatabaseLoggerHook{Logger: logger.NewLogger(logger.WithPrefix("DB.MOCK"))}}
+	db := NewDatabase(WithHook(hook), WithLogger(hook.Logger))
+	db.DB = sqlx.NewDb(mdb, "postgres")
+	return db, mock, hook
+}
+
+func TestInTxCommit(t *testing.T) {
+	db, mock, hook := newMockDatabase(t)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users").WithArgs("name", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT name").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("name"))
+	mock.ExpectCommit()
+
+	err := db.InTx(context.Background(), nil, func(tx *Tx) error {
+		if _, err := tx.Exec("UPDATE users SET name = $1 WHERE id = $2", "name", 1); err != nil {
+			return err
+		}
+		var name string
+		return tx.Get(&name, "SELECT name FROM users WHERE id = $1", 1)
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"BEGIN",
+		"UPDATE users SET name = $1 WHERE id = $2",
+		"SELECT name FROM users WHERE id = $1",
+		"COMMIT",
+	}, hook.queries)
+}
+
+func TestTxNamed(t *testing.T) {
+	db, mock, hook := newMockDatabase(t)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users").WithArgs("name", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT name").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("name"))
+	mock.ExpectCommit()
+
+	user := map[string]interface{}{"id": 1, "name": "name"}
+	err := db.InTx(context.Background(), nil, func(tx *Tx) error {
+		if _, err := tx.NamedExecContext(tx.Context(), "UPDATE users SET name = :name WHERE id = :id", user); err != nil {
+			return err
+		}
+		var name string
+		return tx.QueryRowContext(tx.Context(), tx.Rebind("SELECT name FROM users WHERE id = ?"), 1).Scan(&name)
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"BEGIN",
+		"UPDATE users SET name = $1 WHERE id = $2",
+		"SELECT name FROM users WHERE id = $1",
+		"COMMIT",
+	}, hook.queries)
+}
+
+func TestInTxRollback(t *testing.T) {
+	db, mock, hook := newMockDatabase(t)
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM users").WillReturnError(errors.New("constraint violated"))
+	mock.ExpectRollback()
+
+	err := db.InTx(context.Background(), nil, func(tx *Tx) error {
+		_, err := tx.Exec("DELETE FROM users")
+		return err
+	})
+	require.EqualError(t, err, "constraint violated")
+	require.Equal(t, []string{"BEGIN", "DELETE FROM users", "ROLLBACK"}, hook.queries)
+	require.Len(t, hook.errors, 1)
+}
+
+func TestInTxPanic(t *testing.T) {
+	db, mock, hook := newMockDatabase(t)
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	require.PanicsWithValue(t, "boom", func() {
+		_ = db.InTx(context.Background(), nil, func(tx *Tx) error {
+			panic("boom")
+		})
+	})
+	require.Equal(t, []string{"BEGIN", "ROLLBACK"}, hook.queries)
+}
+
+func TestInTxContext(t *testing.T) {
+	db, mock, hook := newMockDatabase(t)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT name").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("name"))
+	mock.ExpectCommit()
+
+	err := db.InTx(context.Background(), nil, func(tx *Tx) error {
+		ctx := tx.Context()
+		require.Same(t, tx, TxFromContext(ctx))
+		if _, err := db.ExecContext(ctx, "UPDATE users SET active = true WHERE id = $1", 1); err != nil {
+			return err
+		}
+		var names []string
+		return db.SelectContext(ctx, &names, "SELECT name FROM users")
+	})
+	require.NoError(t, err)
+	require.Len(t, hook.queries, 4)
+	require.Nil(t, TxFromContext(context.Background()))
+}
+
+func TestInTxSavepoint(t *testing.T) {
+	db, mock, hook := newMockDatabase(t)
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO orders").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO coupons").WillReturnError(errors.New("duplicate"))
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SAVEPOINT sp_2").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO points").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("RELEASE SAVEPOINT sp_2").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	err := db.InTx(context.Background(), nil, func(tx *Tx) error {
+		ctx := tx.Context()
+		if _, err := db.ExecContext(ctx, "INSERT INTO orders DEFAULT VALUES"); err != nil {
+			return err
+		}
+
+		err := db.InTx(ctx, nil, func(nested *Tx) error {
+			require.Same(t, tx, nested)
+			_, err := db.ExecContext(ctx, "INSERT INTO coupons DEFAULT VALUES")
+			return err
+		})
+		require.EqualError(t, err, "duplicate")
+
+		return db.InTx(ctx, nil, func(*Tx) error {
+			_, err := db.ExecContext(ctx, "INSERT INTO points DEFAULT VALUES")
+			return err
+		})
+	})
+	require.NoError(t, err)
+	require.Equal(t, "COMMIT", hook.queries[len(hook.queries)-1])
+}
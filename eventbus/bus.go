@@ -0,0 +1,138 @@
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	json "github.com/json-iterator/go"
+	"github.com/transerver/commons/logger"
+	"time"
+)
+
+var ErrBusClosed = errors.New("eventbus: the bus is closed")
+
+// Event is a message published to a topic, the Data is the JSON encoded payload
+type Event struct {
+	Topic string
+	Data  json.RawMessage
+}
+
+// Decode decodes the payload of the event to v
+func (e *Event) Decode(v interface{}) error {
+	return json.Unmarshal(e.Data, v)
+}
+
+// Handler handles the events of the subscribed topic, the errors are logged by the bus
+type Handler func(ctx context.Context, event *Event) error
+
+// Middleware wraps the Handler, e.g. recovering, logging or tracing
+type Middleware func(next Handler) Handler
+
+// Chain wraps the handler with the middlewares, the first middleware is the outermost
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// Typed returns a Handler which decodes the payload to T before calling fn
+func Typed[T any](fn func(ctx context.Context, topic string, payload T) error) Handler {
+	return func(ctx context.Context, event *Event) error {
+		var payload T
+		if err := event.Decode(&payload); err != nil {
+			return fmt.Errorf("eventbus: decode the payload of [%s] fail: %w", event.Topic, err)
+		}
+		return fn(ctx, event.Topic, payload)
+	}
+}
+
+// Bus publishes the events to the topics and delivers them to the subscriptions of the topics
+type Bus interface {
+	// Publish encodes v to JSON and publishes it to the topic
+	Publish(ctx context.Context, topic string, v interface{}) error
+
+	// Subscribe delivers the events of the topic to the handler wrapped with the middlewares,
+	// the middlewares of the bus are the outermost. The events of a subscription are handled in order.
+	Subscribe(ctx context.Context, topic string, handler Handler, middlewares ...Middleware) (Subscription, error)
+
+	// Close unsubscribes all the subscriptions
+	Close() error
+}
+
+type Subscription interface {
+	Topic() string
+
+	// Unsubscribe stops delivering the events, and waits for the handling event
+	// to finish until ctx is done
+	Unsubscribe(ctx context.Context) error
+}
+
+type options struct {
+	prefix        string
+	middlewares   []Middleware
+	logger        *logger.Logger
+	retryInterval time.Duration
+}
+
+type Option func(o *options)
+
+// WithPrefix settings the prefix of the redis channels, it's ignored by the MemoryBus
+func WithPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = prefix
+	}
+}
+
+// WithMiddleware appends the middlewares which wrap the handlers of all the subscriptions
+func WithMiddleware(middlewares ...Middleware) Option {
+	return func(o *options) {
+		o.middlewares = append(o.middlewares, middlewares...)
+	}
+}
+
+func WithLogger(logger *logger.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithRetryInterval settings how long to wait before resubscribing after the connection dropped
+func WithRetryInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.retryInterval = interval
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{retryInterval: time.Second}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.logger == nil {
+		o.logger = logger.NewLogger(logger.WithPrefix("EVENTBUS"))
+	}
+	return o
+}
+
+// handler returns the handler of a subscription wrapped with the middlewares of the bus
+func (o *options) handler(handler Handler, middlewares []Middleware) Handler {
+	return Chain(Chain(handler, middlewares...), o.middlewares...)
+}
+
+// handle calls the handler and logs the error
+func (o *options) handle(ctx context.Context, handler Handler, event *Event) {
+	if err := handler(ctx, event); err != nil {
+		o.logger.Errorf("handle the event of [%s] fail: %+v", event.Topic, err)
+	}
+}
+
+// waitDone waits for done closed until ctx is done
+func waitDone(ctx context.Context, done <-chan struct{}) error {
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
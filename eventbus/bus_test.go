@@ -0,0 +1,165 @@
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/transerver/commons/internal/redistest"
+	"github.com/transerver/commons/redis"
+	"sync"
+	"testing"
+	"time"
+)
+
+var mr *miniredis.Miniredis
+
+func TestMain(m *testing.M) {
+	mr = redistest.Start(redis.SetConfig)
+	redistest.Run(m, mr)
+}
+
+type order struct {
+	ID     int    `json:"id"`
+	Status string `json:"status"`
+}
+
+// collector records the orders received by the handler
+type collector struct {
+	orders []order
+	mutex  sync.Mutex
+}
+
+func (c *collector) handle(_ context.Context, _ string, o order) error {
+	c.mutex.Lock()
+	c.orders = append(c.orders, o)
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *collector) received() []order {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]order(nil), c.orders...)
+}
+
+func TestMemoryBus(t *testing.T) {
+	ctx := context.Background()
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, event *Event) error {
+				calls = append(calls, name)
+				return next(ctx, event)
+			}
+		}
+	}
+
+	bus := NewMemoryBus(WithMiddleware(trace("bus"), Recover()))
+	c := &collector{}
+	sub, err := bus.Subscribe(ctx, "order", Typed(c.handle), trace("sub"))
+	require.NoError(t, err)
+	_, err = bus.Subscribe(ctx, "order", func(ctx context.Context, event *Event) error {
+		panic("boom")
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, bus.Publish(ctx, "order", order{ID: 1, Status: "paid"}))
+	require.NoError(t, bus.Publish(ctx, "other", order{ID: 2}))
+	require.Equal(t, []order{{ID: 1, Status: "paid"}}, c.received())
+	require.Equal(t, []string{"bus", "sub", "bus"}, calls)
+
+	require.NoError(t, sub.Unsubscribe(ctx))
+	require.NoError(t, bus.Publish(ctx, "order", order{ID: 3}))
+	require.Len(t, c.received(), 1)
+
+	require.NoError(t, bus.Close())
+	_, err = bus.Subscribe(ctx, "order", Typed(c.handle))
+	require.ErrorIs(t, err, ErrBusClosed)
+}
+
+func TestMemoryBusRepublish(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	bus := NewMemoryBus()
+	var ids []int
+	_, err := bus.Subscribe(ctx, "order", Typed(func(ctx context.Context, topic string, o order) error {
+		ids = append(ids, o.ID)
+		if o.ID < 3 {
+			return bus.Publish(ctx, "order", order{ID: o.ID + 1})
+		}
+		return nil
+	}))
+	require.NoError(t, err)
+	var sub Subscription
+	sub, err = bus.Subscribe(ctx, "once", func(ctx context.Context, event *Event) error {
+		ids = append(ids, 0)
+		return sub.Unsubscribe(ctx)
+	})
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- bus.Publish(ctx, "order", order{ID: 1})
+	}()
+	select {
+	case err = <-done:
+		require.NoError(t, err)
+	case <-ctx.Done():
+		t.Fatal("the handler re-publishing deadlocks")
+	}
+	require.Equal(t, []int{1, 2, 3}, ids)
+
+	require.NoError(t, bus.Publish(ctx, "once", order{}))
+	require.NoError(t, bus.Publish(ctx, "once", order{}))
+	require.Equal(t, []int{1, 2, 3, 0}, ids)
+}
+
+func TestRedisBus(t *testing.T) {
+	ctx := context.Background()
+	bus := NewRedisBus(WithPrefix("test:"), WithRetryInterval(time.Millisecond*50))
+	defer bus.Close()
+
+	c := &collector{}
+	sub, err := bus.Subscribe(ctx, "order", Typed(c.handle))
+	require.NoError(t, err)
+	require.NoError(t, bus.Publish(ctx, "order", order{ID: 1, Status: "paid"}))
+	require.Eventually(t, func() bool { return len(c.received()) == 1 }, time.Second, time.Millisecond*10)
+	require.Equal(t, order{ID: 1, Status: "paid"}, c.received()[0])
+
+	// the subscription resubscribes after redis restarted
+	mr.Close()
+	time.Sleep(time.Millisecond * 100)
+	require.NoError(t, mr.Restart())
+	require.Eventually(t, func() bool {
+		_ = bus.Publish(ctx, "order", order{ID: 2})
+		return len(c.received()) > 1
+	}, time.Second*3, time.Millisecond*100)
+
+	require.NoError(t, sub.Unsubscribe(ctx))
+	count := len(c.received())
+	require.NoError(t, bus.Publish(ctx, "order", order{ID: 3}))
+	time.Sleep(time.Millisecond * 50)
+	require.Len(t, c.received(), count)
+}
+
+func TestUnsubscribeWaitsHandling(t *testing.T) {
+	ctx := context.Background()
+	bus := NewRedisBus(WithPrefix("test:"))
+	defer bus.Close()
+
+	started := make(chan struct{})
+	var finished bool
+	sub, err := bus.Subscribe(ctx, "slow", func(ctx context.Context, event *Event) error {
+		close(started)
+		time.Sleep(time.Millisecond * 100)
+		finished = true
+		return errors.New("logged")
+	})
+	require.NoError(t, err)
+	require.NoError(t, bus.Publish(ctx, "slow", "payload"))
+	<-started
+
+	require.NoError(t, sub.Unsubscribe(ctx))
+	require.True(t, finished)
+}
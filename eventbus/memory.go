@@ -0,0 +1,138 @@
+package eventbus
+
+import (
+	"context"
+	json "github.com/json-iterator/go"
+	"sync"
+)
+
+// MemoryBus is an in-process Bus for tests, Publish delivers the event to
+// the subscriptions synchronously and returns after all of them handled it.
+// The handlers may Publish or Unsubscribe with the ctx they received, the events
+// published to the handling subscription are delivered after the handler returned
+type MemoryBus struct {
+	opts   *options
+	subs   map[string][]*memorySubscription
+	mutex  sync.RWMutex
+	closed bool
+}
+
+var _ Bus = (*MemoryBus)(nil)
+
+func NewMemoryBus(opts ...Option) *MemoryBus {
+	return &MemoryBus{opts: newOptions(opts), subs: make(map[string][]*memorySubscription)}
+}
+
+func (b *MemoryBus) Publish(ctx context.Context, topic string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	b.mutex.RLock()
+	subs := append([]*memorySubscription(nil), b.subs[topic]...)
+	b.mutex.RUnlock()
+
+	for _, sub := range subs {
+		sub.deliver(ctx, &Event{Topic: topic, Data: data})
+	}
+	return nil
+}
+
+func (b *MemoryBus) Subscribe(_ context.Context, topic string, handler Handler, middlewares ...Middleware) (Subscription, error) {
+	sub := &memorySubscription{bus: b, topic: topic, handler: b.opts.handler(handler, middlewares)}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return nil, ErrBusClosed
+	}
+	b.subs[topic] = append(b.subs[topic], sub)
+	return sub, nil
+}
+
+func (b *MemoryBus) Close() error {
+	b.mutex.Lock()
+	subs := b.subs
+	b.subs = make(map[string][]*memorySubscription)
+	b.closed = true
+	b.mutex.Unlock()
+
+	for _, topicSubs := range subs {
+		for _, sub := range topicSubs {
+			_ = sub.Unsubscribe(context.Background())
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBus) remove(sub *memorySubscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	subs := b.subs[sub.topic]
+	for i, s := range subs {
+		if s == sub {
+			b.subs[sub.topic] = append(subs[:i:i], subs[i+1:]...)
+			break
+		}
+	}
+}
+
+type memorySubscription struct {
+	bus     *MemoryBus
+	topic   string
+	handler Handler
+
+	// mutex serializes the events and makes Unsubscribe wait for the handling one
+	mutex        sync.Mutex
+	unsubscribed bool
+
+	// pending the events published by the handler of the subscription itself, guarded by the mutex
+	pending []*Event
+}
+
+func (s *memorySubscription) Topic() string {
+	return s.topic
+}
+
+// handling reports whether ctx is of the handler of s, which holds the mutex already
+func (s *memorySubscription) handling(ctx context.Context) bool {
+	return ctx != nil && ctx.Value(s) != nil
+}
+
+func (s *memorySubscription) deliver(ctx context.Context, event *Event) {
+	if s.handling(ctx) {
+		s.pending = append(s.pending, event)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ctx = context.WithValue(ctx, s, true)
+	for !s.unsubscribed {
+		s.bus.opts.handle(ctx, s.handler, event)
+		if len(s.pending) == 0 {
+			return
+		}
+		event, s.pending = s.pending[0], s.pending[1:]
+	}
+	s.pending = nil
+}
+
+func (s *memorySubscription) Unsubscribe(ctx context.Context) error {
+	s.bus.remove(s)
+	if s.handling(ctx) {
+		s.unsubscribed = true
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.mutex.Lock()
+		s.unsubscribed = true
+		s.mutex.Unlock()
+		close(done)
+	}()
+	return waitDone(ctx, done)
+}
@@ -0,0 +1,34 @@
+package eventbus
+
+import (
+	"context"
+	"fmt"
+	"github.com/transerver/commons/logger"
+	"time"
+)
+
+// Recover returns a Middleware which turns the panic of the handler into an error
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, event *Event) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("panic: %v", r)
+				}
+			}()
+			return next(ctx, event)
+		}
+	}
+}
+
+// Logging returns a Middleware which logs every event at debug level with the time taken
+func Logging(logger *logger.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, event *Event) error {
+			start := time.Now()
+			err := next(ctx, event)
+			logger.Debugf("EVENT: %s, Took: %s", event.Topic, time.Since(start))
+			return err
+		}
+	}
+}
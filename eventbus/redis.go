@@ -0,0 +1,142 @@
+package eventbus
+
+import (
+	"context"
+	goredis "github.com/go-redis/redis/v8"
+	json "github.com/json-iterator/go"
+	"github.com/transerver/commons/redis"
+	"sync"
+	"time"
+)
+
+// RedisBus is a Bus on redis pub/sub with the shared redis.Client(), every subscription has its own connection.
+// The events published while a subscription is disconnected are lost, it's at-most-once delivery.
+type RedisBus struct {
+	opts   *options
+	subs   map[*redisSubscription]struct{}
+	mutex  sync.Mutex
+	closed bool
+}
+
+var _ Bus = (*RedisBus)(nil)
+
+func NewRedisBus(opts ...Option) *RedisBus {
+	return &RedisBus{opts: newOptions(opts), subs: make(map[*redisSubscription]struct{})}
+}
+
+func (b *RedisBus) Publish(ctx context.Context, topic string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return redis.Client().Publish(ctx, b.opts.prefix+topic, data).Err()
+}
+
+// Subscribe subscribes the topic and waits for the confirmation until ctx is done
+func (b *RedisBus) Subscribe(ctx context.Context, topic string, handler Handler, middlewares ...Middleware) (Subscription, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return nil, ErrBusClosed
+	}
+
+	pubsub := redis.Client().Subscribe(ctx, b.opts.prefix+topic)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, err
+	}
+
+	sub := &redisSubscription{
+		bus:     b,
+		topic:   topic,
+		handler: b.opts.handler(handler, middlewares),
+		pubsub:  pubsub,
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	b.subs[sub] = struct{}{}
+	go sub.run()
+	return sub, nil
+}
+
+func (b *RedisBus) Close() error {
+	b.mutex.Lock()
+	subs := b.subs
+	b.subs = make(map[*redisSubscription]struct{})
+	b.closed = true
+	b.mutex.Unlock()
+
+	for sub := range subs {
+		_ = sub.Unsubscribe(context.Background())
+	}
+	return nil
+}
+
+func (b *RedisBus) remove(sub *redisSubscription) {
+	b.mutex.Lock()
+	delete(b.subs, sub)
+	b.mutex.Unlock()
+}
+
+type redisSubscription struct {
+	bus     *RedisBus
+	topic   string
+	handler Handler
+	pubsub  *goredis.PubSub
+	once    sync.Once
+	closing chan struct{}
+	done    chan struct{}
+}
+
+func (s *redisSubscription) Topic() string {
+	return s.topic
+}
+
+// run receives the messages until unsubscribed, the connection is re-established
+// and the channel is resubscribed by the next receive after it dropped
+func (s *redisSubscription) run() {
+	defer close(s.done)
+
+	logger := s.bus.opts.logger
+	lost := false
+	for {
+		msg, err := s.pubsub.Receive(context.Background())
+		if err != nil {
+			select {
+			case <-s.closing:
+				return
+			default:
+			}
+
+			if !lost {
+				logger.Warnf("subscription of [%s] lost: %+v, resubscribing", s.topic, err)
+				lost = true
+			}
+			select {
+			case <-s.closing:
+				return
+			case <-time.After(s.bus.opts.retryInterval):
+			}
+			continue
+		}
+
+		switch m := msg.(type) {
+		case *goredis.Subscription:
+			if lost {
+				logger.Infof("resubscribed to [%s]", s.topic)
+				lost = false
+			}
+		case *goredis.Message:
+			s.bus.opts.handle(context.Background(), s.handler, &Event{Topic: s.topic, Data: json.RawMessage(m.Payload)})
+		}
+	}
+}
+
+func (s *redisSubscription) Unsubscribe(ctx context.Context) error {
+	s.once.Do(func() {
+		close(s.closing)
+		_ = s.pubsub.Close()
+		s.bus.remove(s)
+	})
+	return waitDone(ctx, s.done)
+}
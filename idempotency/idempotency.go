@@ -0,0 +1,284 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/gin-gonic/gin"
+	json "github.com/json-iterator/go"
+	"github.com/transerver/commons/logger"
+	"github.com/transerver/commons/redis"
+	"github.com/transerver/commons/resp"
+	"github.com/transerver/commons/session"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// HeaderKey the request header which carries the idempotency key
+	HeaderKey = "Idempotency-Key"
+
+	// HeaderReplayed the response header which is set on the replayed responses
+	HeaderReplayed = "Idempotent-Replayed"
+
+	defaultPrefix      = "idempotency:"
+	defaultTTL         = 24 * time.Hour
+	defaultLockTTL     = 30 * time.Second
+	defaultMaxBodySize = 1 << 20
+)
+
+// errBodyTooLarge the body of the request exceeds the max body size
+var errBodyTooLarge = errors.New("idempotency: the request body is too large")
+
+// unstoredHeaders the response headers which belong to the first client or connection, they are not replayed
+var unstoredHeaders = []string{"Set-Cookie", "Date", "Connection", "Keep-Alive", "Transfer-Encoding", "Upgrade"}
+
+// record is the first response of the key, it's replayed to the retries
+type record struct {
+	Fingerprint string      `json:"fingerprint"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+}
+
+type options struct {
+	prefix      string
+	ttl         time.Duration
+	lockTTL     time.Duration
+	maxBodySize int64
+	scope       func(c *gin.Context) string
+}
+
+type Option func(o *options)
+
+// WithPrefix settings the prefix of the keys, the default is "idempotency:"
+func WithPrefix(prefix string) Option {
+	return func(o *options) {
+		if len(prefix) > 0 && !strings.HasSuffix(prefix, ":") {
+			prefix += ":"
+		}
+		o.prefix = prefix
+	}
+}
+
+// WithTTL settings how long the first response is kept for replay, the default is 24h
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+// WithLockTTL settings the ttl of the lock held while the request is processing,
+// the lock is refreshed by the watchdog until the request finished
+func WithLockTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.lockTTL = ttl
+	}
+}
+
+// WithMaxBodySize settings the max size of the request body read for the fingerprint, the default is 1MB.
+// The requests with a larger body are aborted with http.StatusRequestEntityTooLarge.
+func WithMaxBodySize(size int64) Option {
+	return func(o *options) {
+		if size > 0 {
+			o.maxBodySize = size
+		}
+	}
+}
+
+// WithScope namespaces the keys by the result of fn instead of the user of the session,
+// e.g. the tenant or the api key, so the keys of different clients never collide
+func WithScope(fn func(c *gin.Context) string) Option {
+	return func(o *options) {
+		if fn != nil {
+			o.scope = fn
+		}
+	}
+}
+
+// Middleware returns a gin middleware which makes the requests with the Idempotency-Key header idempotent.
+// The key is claimed by obtaining a lock, the concurrent duplicates are aborted with http.StatusConflict.
+// The first response except 5xx is stored and replayed to the retries with the same payload,
+// the retries with a different payload are aborted with http.StatusConflict and resp.CodeConflict.
+// The requests without the header, or failed to access redis, are let through.
+// The keys are scoped by the method and path of the request, and the user of the session loaded by
+// the session.Middleware, the anonymous requests should be scoped by WithScope if the clients are identifiable.
+func Middleware(opts ...Option) gin.HandlerFunc {
+	o := &options{prefix: defaultPrefix, ttl: defaultTTL, lockTTL: defaultLockTTL, maxBodySize: defaultMaxBodySize, scope: sessionScope}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return func(c *gin.Context) {
+		key := c.GetHeader(HeaderKey)
+		if len(key) == 0 {
+			c.Next()
+			return
+		}
+		key = o.prefix + o.scope(c) + ":" + c.Request.Method + ":" + c.Request.URL.Path + ":" + key
+
+		fingerprint, err := fingerprintOf(c, o.maxBodySize)
+		if err == errBodyTooLarge {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, resp.Msg(resp.CodeParamErr, resp.CodeParamErr.String()))
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, resp.Msg(resp.CodeParamErr, resp.CodeParamErr.String()))
+			return
+		}
+
+		ctx := c.Request.Context()
+		if replayed, err := replay(ctx, c, key, fingerprint); err != nil {
+			logger.Errorf("idempotency: load the response of [%s] fail: %+v", key, err)
+			c.Next()
+			return
+		} else if replayed {
+			return
+		}
+
+		lock, err := redis.ObtainCtx(ctx, o.lockTTL, "%s:lock", key)
+		if err != nil {
+			logger.Errorf("idempotency: claim the key [%s] fail: %+v", key, err)
+			c.Next()
+			return
+		}
+		if !lock.Locked {
+			conflict(c)
+			return
+		}
+		lock.WatchDog(ctx)
+		defer func() {
+			lock.LoggedRelease()
+			// the fencing token isn't used, the counter is dropped instead of kept for every key
+			if err := redis.Client().Del(context.Background(), redis.FencingKey(lock.Key)).Err(); err != nil {
+				logger.Errorf("idempotency: delete the fencing counter of [%s] fail: %+v", key, err)
+			}
+		}()
+
+		// the first request may finish between the replay check and the lock obtained
+		if replayed, err := replay(ctx, c, key, fingerprint); err != nil {
+			logger.Errorf("idempotency: load the response of [%s] fail: %+v", key, err)
+		} else if replayed {
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			return
+		}
+		data, err := json.Marshal(&record{
+			Fingerprint: fingerprint,
+			Status:      status,
+			Header:      storedHeader(recorder.Header()),
+			Body:        recorder.body.Bytes(),
+		})
+		if err == nil {
+			err = redis.Client().Set(context.Background(), key, data, o.ttl).Err()
+		}
+		if err != nil {
+			logger.Errorf("idempotency: store the response of [%s] fail: %+v", key, err)
+		}
+	}
+}
+
+// replay writes the stored response of the key if any, returns whether the request is finished
+func replay(ctx context.Context, c *gin.Context, key, fingerprint string) (bool, error) {
+	data, err := redis.Client().Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	var r record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return false, err
+	}
+	if r.Fingerprint != fingerprint {
+		conflict(c)
+		return true, nil
+	}
+
+	header := c.Writer.Header()
+	for name, values := range r.Header {
+		header[name] = values
+	}
+	header.Set(HeaderReplayed, "true")
+	c.Status(r.Status)
+	_, _ = c.Writer.Write(r.Body)
+	c.Abort()
+	return true, nil
+}
+
+// sessionScope returns the user id of the session, empty if the request is anonymous
+func sessionScope(c *gin.Context) string {
+	if sess := session.FromContext(c); sess != nil {
+		return sess.UserID
+	}
+	return ""
+}
+
+// storedHeader returns the header of the response to store, the unstoredHeaders are removed
+func storedHeader(header http.Header) http.Header {
+	header = header.Clone()
+	for _, name := range unstoredHeaders {
+		header.Del(name)
+	}
+	return header
+}
+
+func conflict(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusConflict, resp.Msg(resp.CodeConflict, resp.CodeConflict.String()))
+}
+
+// fingerprintOf returns the sha256 of the method, path and body of the request,
+// the body is restored for the handlers. Returns errBodyTooLarge if the body exceeds maxBodySize.
+func fingerprintOf(c *gin.Context, maxBodySize int64) (string, error) {
+	if c.Request.ContentLength > maxBodySize {
+		return "", errBodyTooLarge
+	}
+
+	var body []byte
+	if c.Request.Body != nil {
+		var err error
+		body, err = io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBodySize))
+		if err != nil && int64(len(body)) == maxBodySize {
+			// the MaxBytesReader fails after the body is read up to the limit
+			return "", errBodyTooLarge
+		} else if err != nil {
+			return "", err
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	h := sha256.New()
+	h.Write([]byte(c.Request.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(c.Request.URL.RequestURI()))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// responseRecorder records the body written to the response
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
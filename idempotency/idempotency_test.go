@@ -0,0 +1,171 @@
+package idempotency
+
+import (
+	"github.com/Charliego93/go-i18n"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"github.com/transerver/commons/internal/redistest"
+	"github.com/transerver/commons/redis"
+	"github.com/transerver/commons/session"
+	"golang.org/x/text/language"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var mr *miniredis.Miniredis
+
+func TestMain(m *testing.M) {
+	mr = redistest.Start(redis.SetConfig)
+	i18n.Localize(language.English, i18n.NewLoaderWithFS(fstest.MapFS{
+		"en.json": {Data: []byte(`{"Conflict": "Conflict"}`)},
+	}))
+	redistest.Run(m, mr)
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var created int
+	router := gin.New()
+	router.POST("/orders", Middleware(WithPrefix("test:idempotency")), func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		created++
+		c.Header("X-Order", "1")
+		c.String(http.StatusCreated, "created %s", body)
+	})
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+		req.Header.Set(HeaderKey, key)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("key-1", "apple")
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Equal(t, "created apple", w.Body.String())
+	require.Empty(t, w.Header().Get(HeaderReplayed))
+
+	w = post("key-1", "apple")
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Equal(t, "created apple", w.Body.String())
+	require.Equal(t, "1", w.Header().Get("X-Order"))
+	require.Equal(t, "true", w.Header().Get(HeaderReplayed))
+	require.Equal(t, 1, created)
+
+	w = post("key-1", "banana")
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Equal(t, 1, created)
+
+	lock, err := redis.Obtain(time.Second*5, "test:idempotency::POST:/orders:key-2:lock")
+	require.NoError(t, err)
+	w = post("key-2", "apple")
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.NoError(t, lock.Release())
+
+	w = post("key-2", "apple")
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Equal(t, 2, created)
+	require.False(t, mr.Exists("test:idempotency::POST:/orders:key-2:lock"))
+	require.False(t, mr.Exists(redis.FencingKey("test:idempotency::POST:/orders:key-2:lock")))
+}
+
+func TestMaxBodySize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var created int
+	router := gin.New()
+	router.POST("/orders", Middleware(WithPrefix("test:idempotency:size"), WithMaxBodySize(4)), func(c *gin.Context) {
+		created++
+		c.Status(http.StatusCreated)
+	})
+
+	post := func(key, body string, contentLength int64) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+		req.ContentLength = contentLength
+		req.Header.Set(HeaderKey, key)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusRequestEntityTooLarge, post("key-1", "apple", 5))
+	// the body without the content length is limited while reading
+	require.Equal(t, http.StatusRequestEntityTooLarge, post("key-2", "apple", -1))
+	require.Equal(t, http.StatusCreated, post("key-3", "pear", -1))
+	require.Equal(t, 1, created)
+}
+
+func TestServerErrorNotStored(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var calls int
+	router := gin.New()
+	router.POST("/pay", Middleware(WithPrefix("test:idempotency:error")), func(c *gin.Context) {
+		calls++
+		c.Status(http.StatusServiceUnavailable)
+	})
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/pay", nil)
+		req.Header.Set(HeaderKey, "key")
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	}
+	require.Equal(t, 2, calls)
+}
+
+func TestScopedBySession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := session.NewStore(session.WithPrefix("test:idempotency:session"))
+	var created int
+	router := gin.New()
+	router.POST("/login/:user", func(c *gin.Context) {
+		_, err := store.Login(c, c.Param("user"), nil)
+		require.NoError(t, err)
+		c.Status(http.StatusNoContent)
+	})
+	router.POST("/orders", store.Middleware(), Middleware(WithPrefix("test:idempotency:scope")), func(c *gin.Context) {
+		created++
+		c.SetCookie("seen", session.FromContext(c).UserID, 60, "/", "", false, true)
+		c.String(http.StatusCreated, "created by %s", session.FromContext(c).UserID)
+	})
+
+	login := func(user string) []*http.Cookie {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/login/"+user, nil))
+		require.Equal(t, http.StatusNoContent, w.Code)
+		return w.Result().Cookies()
+	}
+	post := func(cookies []*http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("apple"))
+		req.Header.Set(HeaderKey, "shared")
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	alice, bob := login("alice"), login("bob")
+	require.Equal(t, "created by alice", post(alice).Body.String())
+
+	// the key of alice is not replayed to bob
+	w := post(bob)
+	require.Equal(t, "created by bob", w.Body.String())
+	require.Empty(t, w.Header().Get(HeaderReplayed))
+	require.Equal(t, 2, created)
+
+	// the cookies of the first response are not replayed
+	w = post(alice)
+	require.Equal(t, "created by alice", w.Body.String())
+	require.Equal(t, "true", w.Header().Get(HeaderReplayed))
+	require.Empty(t, w.Header().Values("Set-Cookie"))
+	require.Equal(t, 2, created)
+}
@@ -0,0 +1,28 @@
+// Package redistest provides the miniredis server shared by the tests of the packages using redis
+package redistest
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/transerver/commons/configs"
+	"github.com/transerver/commons/logger"
+	"os"
+	"testing"
+)
+
+// Start starts a miniredis server and configures the redis client with it by setConfig,
+// which is redis.SetConfig, the redis package can't be imported here because its own tests use this
+func Start(setConfig func(config *configs.RedisConfig)) *miniredis.Miniredis {
+	mr := miniredis.NewMiniRedis()
+	if err := mr.Start(); err != nil {
+		logger.Panicln(err)
+	}
+	setConfig(&configs.RedisConfig{Addrs: []string{mr.Addr()}})
+	return mr
+}
+
+// Run runs the tests, closes the server and exits with the result of the tests
+func Run(m *testing.M, mr *miniredis.Miniredis) {
+	code := m.Run()
+	mr.Close()
+	os.Exit(code)
+}
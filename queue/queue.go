@@ -0,0 +1,438 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	goredis "github.com/go-redis/redis/v8"
+	json "github.com/json-iterator/go"
+	"github.com/transerver/commons/logger"
+	"github.com/transerver/commons/redis"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPrefix      = "queue:"
+	defaultVisibility  = time.Minute
+	defaultMaxAttempts = 5
+	defaultBackoff     = time.Second
+	defaultMaxBackoff  = time.Minute * 10
+	defaultPoll        = time.Second
+
+	// moveLimit the maximum number of the delayed or expired jobs moved to ready at once
+	moveLimit = 100
+)
+
+const luaNow = `redis.replicate_commands()
+local t = redis.call("time")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+`
+
+var (
+	// KEYS: ready, delayed, active, jobs, attempts
+	// ARGV: visibility timeout ms
+	luaDequeue = goredis.NewScript(luaNow + `local due = redis.call("zrangebyscore", KEYS[2], "-inf", now, "limit", 0, ` + strconv.Itoa(moveLimit) + `)
+for _, id in ipairs(due) do
+	redis.call("zrem", KEYS[2], id)
+	redis.call("lpush", KEYS[1], id)
+end
+local expired = redis.call("zrangebyscore", KEYS[3], "-inf", now, "limit", 0, ` + strconv.Itoa(moveLimit) + `)
+for _, id in ipairs(expired) do
+	redis.call("zrem", KEYS[3], id)
+	redis.call("lpush", KEYS[1], id)
+end
+local id = redis.call("rpop", KEYS[1])
+if not id then return false end
+redis.call("zadd", KEYS[3], now + tonumber(ARGV[1]), id)
+local attempts = redis.call("hincrby", KEYS[5], id, 1)
+return {id, redis.call("hget", KEYS[4], id) or "", attempts}`)
+
+	// KEYS: ready, delayed, jobs
+	// ARGV: id, job, delay ms
+	luaEnqueue = goredis.NewScript(luaNow + `redis.call("hset", KEYS[3], ARGV[1], ARGV[2])
+local delay = tonumber(ARGV[3])
+if delay > 0 then
+	redis.call("zadd", KEYS[2], now + delay, ARGV[1])
+else
+	redis.call("lpush", KEYS[1], ARGV[1])
+end
+return 1`)
+
+	// KEYS: active, jobs, attempts, errors
+	// ARGV: id
+	luaAck = goredis.NewScript(`if redis.call("zrem", KEYS[1], ARGV[1]) == 0 then return 0 end
+redis.call("hdel", KEYS[2], ARGV[1])
+redis.call("hdel", KEYS[3], ARGV[1])
+redis.call("hdel", KEYS[4], ARGV[1])
+return 1`)
+
+	// KEYS: active, delayed, errors
+	// ARGV: id, delay ms, error
+	luaRetry = goredis.NewScript(luaNow + `if redis.call("zrem", KEYS[1], ARGV[1]) == 0 then return 0 end
+redis.call("hset", KEYS[3], ARGV[1], ARGV[3])
+redis.call("zadd", KEYS[2], now + tonumber(ARGV[2]), ARGV[1])
+return 1`)
+
+	// KEYS: active, dead, errors
+	// ARGV: id, error
+	luaBury = goredis.NewScript(`if redis.call("zrem", KEYS[1], ARGV[1]) == 0 then return 0 end
+redis.call("hset", KEYS[3], ARGV[1], ARGV[2])
+redis.call("lpush", KEYS[2], ARGV[1])
+return 1`)
+
+	// KEYS: dead, ready, attempts, errors
+	// ARGV: id
+	luaRetryDead = goredis.NewScript(`if redis.call("lrem", KEYS[1], 1, ARGV[1]) == 0 then return 0 end
+redis.call("hdel", KEYS[3], ARGV[1])
+redis.call("hdel", KEYS[4], ARGV[1])
+redis.call("lpush", KEYS[2], ARGV[1])
+return 1`)
+
+	// KEYS: dead, jobs, attempts, errors
+	// ARGV: id
+	luaDeleteDead = goredis.NewScript(`if redis.call("lrem", KEYS[1], 1, ARGV[1]) == 0 then return 0 end
+redis.call("hdel", KEYS[2], ARGV[1])
+redis.call("hdel", KEYS[3], ARGV[1])
+redis.call("hdel", KEYS[4], ARGV[1])
+return 1`)
+
+	// ErrJobNotFound is returned when the job is not in the expected state,
+	// e.g. it's acked by another worker after the visibility timeout
+	ErrJobNotFound = errors.New("queue: job not found")
+)
+
+// Job is the unit of work in the queue, the payload is JSON encoded
+type Job struct {
+	ID          string          `json:"id"`
+	Queue       string          `json:"queue"`
+	Payload     json.RawMessage `json:"payload"`
+	MaxAttempts int             `json:"maxAttempts"`
+	CreatedAt   time.Time       `json:"createdAt"`
+
+	// Attempts is the number of times the job has been delivered
+	Attempts int `json:"-"`
+
+	// LastError is the error of the last failed attempt
+	LastError string `json:"-"`
+}
+
+// Decode unmarshals the payload of the job to v
+func (j *Job) Decode(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+type Queue struct {
+	name        string
+	prefix      string
+	visibility  time.Duration
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	poll        time.Duration
+	Logger      *logger.Logger
+}
+
+type Option func(q *Queue)
+
+// WithPrefix settings the prefix of the redis keys, default is "queue:"
+func WithPrefix(prefix string) Option {
+	return func(q *Queue) {
+		if !strings.HasSuffix(prefix, ":") {
+			prefix += ":"
+		}
+		q.prefix = prefix
+	}
+}
+
+// WithVisibilityTimeout settings how long a job is invisible to other workers after delivered,
+// the job is requeued when it's not acked within the timeout, e.g. the worker crashed
+func WithVisibilityTimeout(timeout time.Duration) Option {
+	return func(q *Queue) {
+		q.visibility = timeout
+	}
+}
+
+// WithMaxAttempts settings the default max attempts of the jobs,
+// the job is moved to the dead-letter list after max attempts, 0 means retry forever
+func WithMaxAttempts(attempts int) Option {
+	return func(q *Queue) {
+		q.maxAttempts = attempts
+	}
+}
+
+// WithBackoff settings the exponential retry backoff, the nth retry is delayed base*2^(n-1) up to max
+func WithBackoff(base, max time.Duration) Option {
+	return func(q *Queue) {
+		q.backoff = base
+		q.maxBackoff = max
+	}
+}
+
+// WithPollInterval settings how long the idle workers wait before polling the queue again
+func WithPollInterval(interval time.Duration) Option {
+	return func(q *Queue) {
+		q.poll = interval
+	}
+}
+
+func WithLogger(logger *logger.Logger) Option {
+	return func(q *Queue) {
+		q.Logger = logger
+	}
+}
+
+func NewQueue(name string, opts ...Option) *Queue {
+	q := &Queue{
+		name:        name,
+		prefix:      defaultPrefix,
+		visibility:  defaultVisibility,
+		maxAttempts: defaultMaxAttempts,
+		backoff:     defaultBackoff,
+		maxBackoff:  defaultMaxBackoff,
+		poll:        defaultPoll,
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	if q.Logger == nil {
+		q.Logger = logger.NewLogger(logger.WithPrefix("QUEUE.%s", strings.ToUpper(name)))
+	}
+	return q
+}
+
+func (q *Queue) Name() string {
+	return q.name
+}
+
+func (q *Queue) key(name string) string {
+	return q.prefix + q.name + ":" + name
+}
+
+type enqueueOption struct {
+	delay       time.Duration
+	maxAttempts int
+}
+
+type EnqueueOption func(o *enqueueOption)
+
+// WithDelay delays the job, it's not delivered to workers until the delay elapsed
+func WithDelay(delay time.Duration) EnqueueOption {
+	return func(o *enqueueOption) {
+		o.delay = delay
+	}
+}
+
+// WithJobMaxAttempts overrides the max attempts of the queue for the job, 0 means retry forever
+func WithJobMaxAttempts(attempts int) EnqueueOption {
+	return func(o *enqueueOption) {
+		o.maxAttempts = attempts
+	}
+}
+
+func (q *Queue) Enqueue(payload interface{}, opts ...EnqueueOption) (*Job, error) {
+	return q.EnqueueCtx(context.Background(), payload, opts...)
+}
+
+// EnqueueCtx encodes the payload to JSON and pushes the job to the queue
+func (q *Queue) EnqueueCtx(ctx context.Context, payload interface{}, opts ...EnqueueOption) (*Job, error) {
+	o := &enqueueOption{maxAttempts: q.maxAttempts}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// the ids are unique in the queue across the producers, the jobs are never overwritten by each other
+	seq, err := redis.Client().Incr(ctx, q.key("seq")).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		ID:          strconv.FormatInt(seq, 10),
+		Queue:       q.name,
+		Payload:     data,
+		MaxAttempts: o.maxAttempts,
+		CreatedAt:   time.Now(),
+	}
+	encoded, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{q.key("ready"), q.key("delayed"), q.key("jobs")}
+	err = luaEnqueue.Run(ctx, redis.Client(), keys, job.ID, encoded, o.delay.Milliseconds()).Err()
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// dequeue delivers a ready job, the delayed jobs which are due and
+// the active jobs which exceeded the visibility timeout are moved to ready first.
+// Returns nil if no job is ready.
+func (q *Queue) dequeue(ctx context.Context) (*Job, error) {
+	keys := []string{q.key("ready"), q.key("delayed"), q.key("active"), q.key("jobs"), q.key("attempts")}
+	res, err := luaDequeue.Run(ctx, redis.Client(), keys, q.visibility.Milliseconds()).Result()
+	if err == goredis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	values, ok := res.([]interface{})
+	if !ok || len(values) != 3 {
+		return nil, fmt.Errorf("queue: unexpected dequeue result %v", res)
+	}
+	id, _ := values[0].(string)
+	data, _ := values[1].(string)
+	attempts, _ := values[2].(int64)
+
+	job := &Job{ID: id}
+	if len(data) == 0 {
+		// the job has been deleted, drop it
+		return nil, q.ack(ctx, job)
+	}
+	if err := json.Unmarshal([]byte(data), job); err != nil {
+		return job, err
+	}
+	job.Attempts = int(attempts)
+	return job, nil
+}
+
+// ack removes the finished job from the queue
+func (q *Queue) ack(ctx context.Context, job *Job) error {
+	keys := []string{q.key("active"), q.key("jobs"), q.key("attempts"), q.key("errors")}
+	return q.result(luaAck.Run(ctx, redis.Client(), keys, job.ID))
+}
+
+// fail retries the job with backoff, or moves it to the dead-letter list after max attempts
+func (q *Queue) fail(ctx context.Context, job *Job, cause error) error {
+	if job.MaxAttempts > 0 && job.Attempts >= job.MaxAttempts {
+		keys := []string{q.key("active"), q.key("dead"), q.key("errors")}
+		return q.result(luaBury.Run(ctx, redis.Client(), keys, job.ID, cause.Error()))
+	}
+
+	keys := []string{q.key("active"), q.key("delayed"), q.key("errors")}
+	delay := q.retryDelay(job.Attempts).Milliseconds()
+	return q.result(luaRetry.Run(ctx, redis.Client(), keys, job.ID, delay, cause.Error()))
+}
+
+func (q *Queue) retryDelay(attempts int) time.Duration {
+	delay := q.backoff
+	for i := 1; i < attempts && delay < q.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > q.maxBackoff {
+		delay = q.maxBackoff
+	}
+	return delay
+}
+
+func (q *Queue) result(cmd *goredis.Cmd) error {
+	n, err := cmd.Int64()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// Stats is the number of jobs in each state of the queue
+type Stats struct {
+	Ready   int64
+	Delayed int64
+	Active  int64
+	Dead    int64
+}
+
+func (q *Queue) Stats() (*Stats, error) {
+	return q.StatsCtx(context.Background())
+}
+
+func (q *Queue) StatsCtx(ctx context.Context) (*Stats, error) {
+	c := redis.Client()
+	ready := c.LLen(ctx, q.key("ready"))
+	delayed := c.ZCard(ctx, q.key("delayed"))
+	active := c.ZCard(ctx, q.key("active"))
+	dead := c.LLen(ctx, q.key("dead"))
+	for _, cmd := range []*goredis.IntCmd{ready, delayed, active, dead} {
+		if err := cmd.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return &Stats{Ready: ready.Val(), Delayed: delayed.Val(), Active: active.Val(), Dead: dead.Val()}, nil
+}
+
+func (q *Queue) DeadJobs(start, stop int64) ([]*Job, error) {
+	return q.DeadJobsCtx(context.Background(), start, stop)
+}
+
+// DeadJobsCtx returns the jobs in the dead-letter list from start to stop, the latest is the first
+func (q *Queue) DeadJobsCtx(ctx context.Context, start, stop int64) ([]*Job, error) {
+	c := redis.Client()
+	ids, err := c.LRange(ctx, q.key("dead"), start, stop).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	data, err := c.HMGet(ctx, q.key("jobs"), ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	attempts, err := c.HMGet(ctx, q.key("attempts"), ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	causes, err := c.HMGet(ctx, q.key("errors"), ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*Job, 0, len(ids))
+	for i, id := range ids {
+		job := &Job{ID: id, Queue: q.name}
+		if s, ok := data[i].(string); ok {
+			if err := json.Unmarshal([]byte(s), job); err != nil {
+				return nil, err
+			}
+		}
+		if s, ok := attempts[i].(string); ok {
+			job.Attempts, _ = strconv.Atoi(s)
+		}
+		if s, ok := causes[i].(string); ok {
+			job.LastError = s
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (q *Queue) RetryDead(id string) error {
+	return q.RetryDeadCtx(context.Background(), id)
+}
+
+// RetryDeadCtx moves the dead job back to the queue with the attempts reset.
+// May return ErrJobNotFound.
+func (q *Queue) RetryDeadCtx(ctx context.Context, id string) error {
+	keys := []string{q.key("dead"), q.key("ready"), q.key("attempts"), q.key("errors")}
+	return q.result(luaRetryDead.Run(ctx, redis.Client(), keys, id))
+}
+
+func (q *Queue) DeleteDead(id string) error {
+	return q.DeleteDeadCtx(context.Background(), id)
+}
+
+// DeleteDeadCtx deletes the dead job permanently.
+// May return ErrJobNotFound.
+func (q *Queue) DeleteDeadCtx(ctx context.Context, id string) error {
+	keys := []string{q.key("dead"), q.key("jobs"), q.key("attempts"), q.key("errors")}
+	return q.result(luaDeleteDead.Run(ctx, redis.Client(), keys, id))
+}
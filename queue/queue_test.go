@@ -0,0 +1,201 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/transerver/commons/internal/redistest"
+	"github.com/transerver/commons/redis"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var mr *miniredis.Miniredis
+
+func TestMain(m *testing.M) {
+	mr = redistest.Start(redis.SetConfig)
+	redistest.Run(m, mr)
+}
+
+type email struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+}
+
+func TestWork(t *testing.T) {
+	q := NewQueue("test:work", WithPollInterval(time.Millisecond*10))
+	for i := 0; i < 10; i++ {
+		_, err := q.Enqueue(email{To: "user@email.com", Subject: "hello"})
+		require.NoError(t, err)
+	}
+
+	var processed int32
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for atomic.LoadInt32(&processed) < 10 {
+			time.Sleep(time.Millisecond * 10)
+		}
+		cancel()
+	}()
+	q.Work(ctx, 3, func(ctx context.Context, job *Job) error {
+		var e email
+		require.NoError(t, job.Decode(&e))
+		require.Equal(t, "hello", e.Subject)
+		atomic.AddInt32(&processed, 1)
+		return nil
+	})
+
+	stats, err := q.Stats()
+	require.NoError(t, err)
+	require.Equal(t, &Stats{}, stats)
+}
+
+func TestDelay(t *testing.T) {
+	now := time.Now()
+	mr.SetTime(now)
+	defer mr.SetTime(time.Time{})
+
+	q := NewQueue("test:delay")
+	_, err := q.Enqueue("delayed", WithDelay(time.Minute))
+	require.NoError(t, err)
+
+	job, err := q.dequeue(context.Background())
+	require.NoError(t, err)
+	require.Nil(t, job)
+
+	mr.SetTime(now.Add(time.Minute))
+	job, err = q.dequeue(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	require.Equal(t, 1, job.Attempts)
+	require.NoError(t, q.ack(context.Background(), job))
+}
+
+func TestVisibilityTimeout(t *testing.T) {
+	now := time.Now()
+	mr.SetTime(now)
+	defer mr.SetTime(time.Time{})
+
+	q := NewQueue("test:visibility", WithVisibilityTimeout(time.Second*30))
+	enqueued, err := q.Enqueue("crash")
+	require.NoError(t, err)
+
+	job, err := q.dequeue(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, enqueued.ID, job.ID)
+
+	job, err = q.dequeue(context.Background())
+	require.NoError(t, err)
+	require.Nil(t, job)
+
+	mr.SetTime(now.Add(time.Second * 31))
+	job, err = q.dequeue(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, enqueued.ID, job.ID)
+	require.Equal(t, 2, job.Attempts)
+	require.NoError(t, q.ack(context.Background(), job))
+}
+
+func TestRetryAndDead(t *testing.T) {
+	now := time.Now()
+	mr.SetTime(now)
+	defer mr.SetTime(time.Time{})
+
+	q := NewQueue("test:dead", WithMaxAttempts(3), WithBackoff(time.Second, time.Second*3))
+	enqueued, err := q.Enqueue("fail")
+	require.NoError(t, err)
+
+	failure := func(ctx context.Context, job *Job) error {
+		return errors.New("always fail")
+	}
+	for i, delay := range []time.Duration{time.Second, time.Second * 2} {
+		processed, err := q.ProcessOne(context.Background(), failure)
+		require.NoError(t, err)
+		require.True(t, processed, "attempt %d", i+1)
+
+		processed, err = q.ProcessOne(context.Background(), failure)
+		require.NoError(t, err)
+		require.False(t, processed, "retry should be delayed")
+
+		now = now.Add(delay)
+		mr.SetTime(now)
+	}
+
+	processed, err := q.ProcessOne(context.Background(), func(ctx context.Context, job *Job) error {
+		panic("panic in handler")
+	})
+	require.NoError(t, err)
+	require.True(t, processed)
+
+	jobs, err := q.DeadJobs(0, -1)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	require.Equal(t, enqueued.ID, jobs[0].ID)
+	require.Equal(t, 3, jobs[0].Attempts)
+	require.Equal(t, "panic: panic in handler", jobs[0].LastError)
+
+	require.NoError(t, q.RetryDead(enqueued.ID))
+	processed, err = q.ProcessOne(context.Background(), func(ctx context.Context, job *Job) error {
+		require.Equal(t, 1, job.Attempts)
+		return nil
+	})
+	require.NoError(t, err)
+	require.True(t, processed)
+	require.ErrorIs(t, q.DeleteDead(enqueued.ID), ErrJobNotFound)
+}
+
+func TestRetryForever(t *testing.T) {
+	now := time.Now()
+	mr.SetTime(now)
+	defer mr.SetTime(time.Time{})
+
+	q := NewQueue("test:forever", WithMaxAttempts(3), WithBackoff(time.Second, time.Second))
+	_, err := q.Enqueue("fail", WithJobMaxAttempts(0))
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		processed, err := q.ProcessOne(context.Background(), func(ctx context.Context, job *Job) error {
+			return errors.New("always fail")
+		})
+		require.NoError(t, err)
+		require.True(t, processed, "attempt %d", i+1)
+
+		now = now.Add(time.Second)
+		mr.SetTime(now)
+	}
+
+	stats, err := q.Stats()
+	require.NoError(t, err)
+	require.Zero(t, stats.Dead)
+	require.Equal(t, int64(1), stats.Delayed)
+}
+
+func TestEnqueueUniqueIds(t *testing.T) {
+	// two producers of the same queue
+	producers := []*Queue{NewQueue("test:ids"), NewQueue("test:ids")}
+	var wg sync.WaitGroup
+	ids := make(chan string, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			job, err := producers[i%2].Enqueue(email{To: "user@email.com"})
+			require.NoError(t, err)
+			ids <- job.ID
+		}(i)
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[string]bool)
+	for id := range ids {
+		require.False(t, seen[id], "duplicated id %s", id)
+		seen[id] = true
+	}
+	stats, err := producers[0].Stats()
+	require.NoError(t, err)
+	require.EqualValues(t, 100, stats.Ready)
+}
@@ -0,0 +1,88 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Handler processes the job, the job is retried if an error is returned or panicked.
+// The ctx is cancelled when the visibility timeout exceeded or the workers stopped.
+type Handler func(ctx context.Context, job *Job) error
+
+// Work starts concurrency workers processing the jobs with handler,
+// it blocks until ctx is done and all the running jobs finished
+func (q *Queue) Work(ctx context.Context, concurrency int, handler Handler) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx, handler)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context, handler Handler) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		processed, err := q.ProcessOne(ctx, handler)
+		if err != nil {
+			q.Logger.Errorf("process job fail: %+v", err)
+		}
+		if processed && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(q.poll):
+		}
+	}
+}
+
+// ProcessOne delivers one job and processes it with handler,
+// returns false if no job is ready
+func (q *Queue) ProcessOne(ctx context.Context, handler Handler) (bool, error) {
+	job, err := q.dequeue(ctx)
+	if err != nil || job == nil {
+		return false, err
+	}
+
+	// the result is recorded even if ctx is done while handling,
+	// otherwise the job is delivered again after the visibility timeout
+	rctx := context.Background()
+	if job.MaxAttempts > 0 && job.Attempts > job.MaxAttempts {
+		return true, q.fail(rctx, job, fmt.Errorf("exceeded max attempts %d", job.MaxAttempts))
+	}
+
+	herr := q.handle(ctx, job, handler)
+	if herr == nil {
+		return true, q.ack(rctx, job)
+	}
+
+	q.Logger.Warnf("job [%s] attempt %d/%d fail: %+v", job.ID, job.Attempts, job.MaxAttempts, herr)
+	return true, q.fail(rctx, job, herr)
+}
+
+func (q *Queue) handle(ctx context.Context, job *Job, handler Handler) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, q.visibility)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
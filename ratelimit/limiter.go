@@ -0,0 +1,273 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/transerver/commons/redis"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:generate stringer -type=Algorithm
+type Algorithm int
+
+const (
+	// FixedWindow counts the requests in a fixed window of Limit.Period
+	FixedWindow Algorithm = iota
+
+	// SlidingWindow logs the timestamp of every request in a sorted set,
+	// and counts the requests in the last Limit.Period
+	SlidingWindow
+
+	// TokenBucket is the GCRA (generic cell rate algorithm) token bucket,
+	// the bucket holds Limit.Burst tokens and refills Limit.Rate tokens per Limit.Period
+	TokenBucket
+)
+
+const defaultPrefix = "ratelimit:"
+
+var (
+	ErrInvalidLimit = errors.New("ratelimit: the rate and period of the limit should be positive")
+	ErrInvalidN     = errors.New("ratelimit: the number of requests should be positive")
+
+	// all the scripts return {allowed, remaining, retry_after_ms, reset_after_ms}
+	luaFixedWindow = goredis.NewScript(`local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local current = tonumber(redis.call("get", KEYS[1]) or "0")
+if current + n > limit then
+	local ttl = redis.call("pttl", KEYS[1])
+	return {0, math.max(limit - current, 0), ttl, ttl}
+end
+current = redis.call("incrby", KEYS[1], n)
+if current == n then redis.call("pexpire", KEYS[1], period) end
+return {1, limit - current, 0, redis.call("pttl", KEYS[1])}`)
+
+	luaSlidingWindow = goredis.NewScript(`redis.replicate_commands()
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local time = redis.call("time")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+redis.call("zremrangebyscore", KEYS[1], "-inf", now - period)
+local count = redis.call("zcard", KEYS[1])
+if count + n > limit then
+	local retry = period
+	local index = count + n - limit - 1
+	if index < count then
+		local entry = redis.call("zrange", KEYS[1], index, index, "withscores")
+		retry = tonumber(entry[2]) + period - now
+	end
+	local first = redis.call("zrange", KEYS[1], 0, 0, "withscores")
+	local reset = 0
+	if #first > 0 then reset = tonumber(first[2]) + period - now end
+	return {0, math.max(limit - count, 0), retry, reset}
+end
+for i = 1, n do
+	redis.call("zadd", KEYS[1], now, ARGV[4] .. ":" .. i)
+end
+redis.call("pexpire", KEYS[1], period)
+return {1, limit - count - n, 0, period}`)
+
+	luaTokenBucket = goredis.NewScript(`redis.replicate_commands()
+local burst = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local time = redis.call("time")
+local now = tonumber(time[1]) * 1000 + tonumber(time[2]) / 1000
+local tolerance = interval * burst
+local tat = tonumber(redis.call("get", KEYS[1]) or "0")
+if tat < now then tat = now end
+local newTat = tat + interval * n
+local diff = now - (newTat - tolerance)
+if diff < 0 then
+	local remaining = math.floor((now - (tat - tolerance)) / interval)
+	return {0, math.max(remaining, 0), math.ceil(-diff), math.ceil(tat - now)}
+end
+local reset = newTat - now
+if reset > 0 then
+	redis.call("set", KEYS[1], tostring(newTat), "px", math.ceil(reset))
+end
+return {1, math.floor(diff / interval), 0, math.ceil(reset)}`)
+)
+
+// Limit is Rate requests per Period,
+// Burst is the maximum requests allowed at once for TokenBucket
+type Limit struct {
+	Rate   int
+	Period time.Duration
+	Burst  int
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d req/%s (burst %d)", l.Rate, l.Period, l.Burst)
+}
+
+func PerSecond(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Second, Burst: rate}
+}
+
+func PerMinute(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Minute, Burst: rate}
+}
+
+func PerHour(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Hour, Burst: rate}
+}
+
+// Result is the result of a rate limit check
+type Result struct {
+	// Allowed reports whether the requests are allowed
+	Allowed bool
+
+	// Remaining is the number of requests still allowed right now
+	Remaining int
+
+	// RetryAfter is the time to wait until the requests would be allowed,
+	// it's zero when the requests are allowed
+	RetryAfter time.Duration
+
+	// ResetAfter is the time until the limit resets to its initial state
+	ResetAfter time.Duration
+}
+
+type Limiter struct {
+	algorithm Algorithm
+	limit     Limit
+	prefix    string
+}
+
+type Option func(*Limiter)
+
+// WithAlgorithm settings the algorithm of the limiter, default is FixedWindow
+func WithAlgorithm(algorithm Algorithm) Option {
+	return func(l *Limiter) {
+		l.algorithm = algorithm
+	}
+}
+
+// WithPrefix settings the prefix of the redis keys, default is "ratelimit:"
+func WithPrefix(prefix string) Option {
+	return func(l *Limiter) {
+		if !strings.HasSuffix(prefix, ":") {
+			prefix += ":"
+		}
+		l.prefix = prefix
+	}
+}
+
+// NewLimiter creates the Limiter of the limit, returns ErrInvalidLimit if the Rate or Period of the limit isn't positive
+func NewLimiter(limit Limit, opts ...Option) (*Limiter, error) {
+	if limit.Rate <= 0 || limit.Period <= 0 {
+		return nil, ErrInvalidLimit
+	}
+
+	l := &Limiter{limit: limit, prefix: defaultPrefix}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.limit.Burst <= 0 {
+		l.limit.Burst = l.limit.Rate
+	}
+	return l, nil
+}
+
+// Allow is shorthand for AllowN(key, 1)
+func (l *Limiter) Allow(key string) (*Result, error) {
+	return l.AllowN(key, 1)
+}
+
+func (l *Limiter) AllowN(key string, n int) (*Result, error) {
+	return l.AllowNCtx(context.Background(), key, n)
+}
+
+// AllowNCtx reports whether n requests keyed by key may happen now,
+// the key is usually the user id, ip or api key. Returns ErrInvalidN if n isn't positive.
+func (l *Limiter) AllowNCtx(ctx context.Context, key string, n int) (*Result, error) {
+	if n <= 0 {
+		return nil, ErrInvalidN
+	}
+
+	var (
+		script *goredis.Script
+		args   []interface{}
+	)
+
+	switch l.algorithm {
+	case FixedWindow:
+		script = luaFixedWindow
+		args = []interface{}{l.limit.Rate, l.limit.Period.Milliseconds(), n}
+	case SlidingWindow:
+		member, err := randomMember()
+		if err != nil {
+			return nil, err
+		}
+		script = luaSlidingWindow
+		args = []interface{}{l.limit.Rate, l.limit.Period.Milliseconds(), n, member}
+	case TokenBucket:
+		interval := float64(l.limit.Period.Microseconds()) / 1000 / float64(l.limit.Rate)
+		script = luaTokenBucket
+		args = []interface{}{l.limit.Burst, strconv.FormatFloat(interval, 'f', -1, 64), n}
+	default:
+		return nil, fmt.Errorf("ratelimit: unknown algorithm %s", l.algorithm)
+	}
+
+	values, err := script.Run(ctx, redis.Client(), []string{l.key(key)}, args...).Result()
+	if err != nil {
+		return nil, err
+	}
+	return parseResult(values)
+}
+
+func (l *Limiter) Reset(key string) error {
+	return l.ResetCtx(context.Background(), key)
+}
+
+// ResetCtx resets the limit of the key
+func (l *Limiter) ResetCtx(ctx context.Context, key string) error {
+	return redis.Client().Del(ctx, l.key(key)).Err()
+}
+
+func (l *Limiter) key(key string) string {
+	return l.prefix + l.algorithm.String() + ":" + key
+}
+
+func parseResult(values interface{}) (*Result, error) {
+	v, ok := values.([]interface{})
+	if !ok || len(v) != 4 {
+		return nil, fmt.Errorf("ratelimit: unexpected result %v", values)
+	}
+
+	ints := make([]int64, len(v))
+	for i, value := range v {
+		if ints[i], ok = value.(int64); !ok {
+			return nil, fmt.Errorf("ratelimit: unexpected result %v", values)
+		}
+	}
+
+	result := &Result{
+		Allowed:   ints[0] == 1,
+		Remaining: int(ints[1]),
+	}
+	if ints[2] > 0 {
+		result.RetryAfter = time.Duration(ints[2]) * time.Millisecond
+	}
+	if ints[3] > 0 {
+		result.ResetAfter = time.Duration(ints[3]) * time.Millisecond
+	}
+	return result, nil
+}
+
+// randomMember generates the unique member of the sliding window log
+func randomMember() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,121 @@
+package ratelimit
+
+import (
+	"github.com/Charliego93/go-i18n"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/transerver/commons/internal/redistest"
+	"github.com/transerver/commons/redis"
+	"golang.org/x/text/language"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var mr *miniredis.Miniredis
+
+func TestMain(m *testing.M) {
+	mr = redistest.Start(redis.SetConfig)
+	i18n.Localize(language.English, i18n.NewLoaderWithFS(fstest.MapFS{
+		"en.json": {Data: []byte(`{"TooManyRequests": "Too many requests"}`)},
+	}))
+	redistest.Run(m, mr)
+}
+
+func TestFixedWindow(t *testing.T) {
+	limiter, err := NewLimiter(PerMinute(3))
+	require.NoError(t, err)
+	for i := 2; i >= 0; i-- {
+		result, err := limiter.Allow("fixed")
+		require.NoError(t, err)
+		require.True(t, result.Allowed)
+		require.Equal(t, i, result.Remaining)
+	}
+
+	result, err := limiter.Allow("fixed")
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+	require.Equal(t, 0, result.Remaining)
+	require.Equal(t, time.Minute, result.RetryAfter)
+
+	mr.FastForward(time.Minute)
+	result, err = limiter.Allow("fixed")
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+}
+
+func TestInvalidLimit(t *testing.T) {
+	_, err := NewLimiter(PerSecond(0))
+	require.ErrorIs(t, err, ErrInvalidLimit)
+	_, err = NewLimiter(Limit{Rate: 1})
+	require.ErrorIs(t, err, ErrInvalidLimit)
+
+	limiter, err := NewLimiter(PerMinute(3))
+	require.NoError(t, err)
+	for _, n := range []int{0, -3} {
+		_, err = limiter.AllowN("invalid", n)
+		require.ErrorIs(t, err, ErrInvalidN)
+	}
+	result, err := limiter.AllowN("invalid", 3)
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	now := time.Now()
+	mr.SetTime(now)
+	defer mr.SetTime(time.Time{})
+
+	limiter, err := NewLimiter(PerMinute(3), WithAlgorithm(SlidingWindow))
+	require.NoError(t, err)
+	result, err := limiter.AllowN("sliding", 2)
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+	require.Equal(t, 1, result.Remaining)
+
+	mr.SetTime(now.Add(time.Second * 30))
+	result, err = limiter.Allow("sliding")
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+	require.Equal(t, 0, result.Remaining)
+
+	result, err = limiter.Allow("sliding")
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+	require.Equal(t, time.Second*30, result.RetryAfter)
+
+	mr.SetTime(now.Add(time.Second * 61))
+	result, err = limiter.AllowN("sliding", 2)
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+	require.Equal(t, 0, result.Remaining)
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	mr.SetTime(now)
+	defer mr.SetTime(time.Time{})
+
+	limiter, err := NewLimiter(Limit{Rate: 10, Period: time.Second, Burst: 5}, WithAlgorithm(TokenBucket))
+	require.NoError(t, err)
+	result, err := limiter.AllowN("bucket", 5)
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+	require.Equal(t, 0, result.Remaining)
+
+	result, err = limiter.Allow("bucket")
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+	require.Equal(t, time.Millisecond*100, result.RetryAfter)
+
+	mr.SetTime(now.Add(time.Millisecond * 200))
+	result, err = limiter.Allow("bucket")
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+	require.Equal(t, 1, result.Remaining)
+
+	require.NoError(t, limiter.Reset("bucket"))
+	result, err = limiter.AllowN("bucket", 5)
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+}
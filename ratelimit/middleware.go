@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/transerver/commons/logger"
+	"github.com/transerver/commons/resp"
+	"github.com/transerver/commons/utils"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// Middleware returns a gin middleware which limits the requests by the key returned from keyFunc,
+// the requests over the limit are aborted with http.StatusTooManyRequests and resp.CodeTooManyRequests.
+// The requests with empty key, or failed to check the limit, e.g. redis unavailable, are let through.
+func Middleware(limiter *Limiter, keyFunc func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := keyFunc(c)
+		if len(key) == 0 {
+			c.Next()
+			return
+		}
+
+		result, err := limiter.AllowNCtx(c.Request.Context(), key, 1)
+		if err != nil {
+			logger.Errorf("ratelimit: check the limit of [%s] fail: %+v", key, err)
+			c.Next()
+			return
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limiter.limit.Rate))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, resp.Msg(resp.CodeTooManyRequests, resp.CodeTooManyRequests.String()))
+			return
+		}
+		c.Next()
+	}
+}
+
+// IPMiddleware returns a Middleware which limits the requests per client ip
+func IPMiddleware(limiter *Limiter) gin.HandlerFunc {
+	return Middleware(limiter, IPKey)
+}
+
+// IPKey returns the client ip of the request as the key
+func IPKey(c *gin.Context) string {
+	return utils.FetchIp(c.Request)
+}
@@ -0,0 +1,54 @@
+package ratelimit
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limiter, err := NewLimiter(PerMinute(2), WithPrefix("test:middleware"))
+	require.NoError(t, err)
+	router := gin.New()
+	router.GET("/orders", Middleware(limiter, func(c *gin.Context) string {
+		return c.GetHeader("X-User")
+	}), func(c *gin.Context) {
+		c.String(http.StatusOK, "orders")
+	})
+
+	get := func(user string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.Header.Set("X-User", user)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 1; i >= 0; i-- {
+		w := get("1")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"))
+		require.Equal(t, strconv.Itoa(i), w.Header().Get("X-RateLimit-Remaining"))
+		require.Empty(t, w.Header().Get("Retry-After"))
+	}
+
+	w := get("1")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"))
+	require.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+	require.Equal(t, "60", w.Header().Get("Retry-After"))
+	require.Contains(t, w.Body.String(), `"code":503`)
+
+	// the other keys and the requests without key are not limited
+	require.Equal(t, http.StatusOK, get("2").Code)
+	require.Equal(t, http.StatusOK, get("").Code)
+	require.Empty(t, get("").Header().Get("X-RateLimit-Limit"))
+
+	mr.FastForward(time.Minute)
+	require.Equal(t, http.StatusOK, get("1").Code)
+}
@@ -0,0 +1,115 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"hash/fnv"
+	"math"
+	"strconv"
+)
+
+// maxBloomBits the maximum size of a redis bitmap, it's 512MB
+const maxBloomBits = 1 << 32
+
+var (
+	ErrInvalidBloomFilter  = errors.New("redis: the capacity should be positive and the false positive rate should be in (0, 1)")
+	ErrBloomFilterTooLarge = errors.New("redis: the bloom filter exceeds the max bitmap size")
+
+	// luaBloomAdd sets the bits of the item, returns 1 if any bit was not set, which means the item was absent
+	luaBloomAdd = redis.NewScript(`local added = 0
+for i = 1, #ARGV do
+	if redis.call("setbit", KEYS[1], ARGV[i], 1) == 0 then added = 1 end
+end
+return added`)
+
+	// luaBloomExists returns 1 if all the bits of the item are set
+	luaBloomExists = redis.NewScript(`for i = 1, #ARGV do
+	if redis.call("getbit", KEYS[1], ARGV[i]) == 0 then return 0 end
+end
+return 1`)
+)
+
+// BloomFilter is a bloom filter stored in a redis bitmap. The bit positions of an item are
+// computed by double hashing of the 128-bit FNV-1a hash, and set or tested in one Lua script.
+type BloomFilter struct {
+	Key    string
+	bits   uint64
+	hashes int
+}
+
+// NewBloomFilter creates a BloomFilter sized for capacity items with the false positive rate fpRate,
+// the rate increases when more items than capacity are added
+func NewBloomFilter(key string, capacity uint64, fpRate float64) (*BloomFilter, error) {
+	if capacity == 0 || fpRate <= 0 || fpRate >= 1 {
+		return nil, ErrInvalidBloomFilter
+	}
+
+	bits := math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	if bits > maxBloomBits {
+		return nil, ErrBloomFilterTooLarge
+	}
+	hashes := int(math.Round(bits / float64(capacity) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &BloomFilter{Key: key, bits: uint64(bits), hashes: hashes}, nil
+}
+
+// Bits returns the size of the bitmap
+func (f *BloomFilter) Bits() uint64 {
+	return f.bits
+}
+
+// Hashes returns the number of hash functions
+func (f *BloomFilter) Hashes() int {
+	return f.hashes
+}
+
+func (f *BloomFilter) Add(item string) (bool, error) {
+	return f.AddCtx(context.Background(), item)
+}
+
+// AddCtx adds the item, returns true if it was absent
+func (f *BloomFilter) AddCtx(ctx context.Context, item string) (bool, error) {
+	added, err := luaBloomAdd.Run(ctx, Client(), []string{f.Key}, f.positions(item)...).Int64()
+	return added == 1, err
+}
+
+func (f *BloomFilter) Exists(item string) (bool, error) {
+	return f.ExistsCtx(context.Background(), item)
+}
+
+// ExistsCtx returns whether the item may be added, false means it's definitely absent
+func (f *BloomFilter) ExistsCtx(ctx context.Context, item string) (bool, error) {
+	exists, err := luaBloomExists.Run(ctx, Client(), []string{f.Key}, f.positions(item)...).Int64()
+	return exists == 1, err
+}
+
+func (f *BloomFilter) Reset() error {
+	return f.ResetCtx(context.Background())
+}
+
+// ResetCtx deletes all the items
+func (f *BloomFilter) ResetCtx(ctx context.Context) error {
+	return Client().Del(ctx, f.Key).Err()
+}
+
+// positions returns the k bit positions of the item
+func (f *BloomFilter) positions(item string) []interface{} {
+	h := fnv.New128a()
+	_, _ = h.Write([]byte(item))
+	sum := h.Sum(nil)
+
+	var h1, h2 uint64
+	for i := 0; i < 8; i++ {
+		h1 = h1<<8 | uint64(sum[i])
+		h2 = h2<<8 | uint64(sum[i+8])
+	}
+
+	positions := make([]interface{}, f.hashes)
+	for i := range positions {
+		positions[i] = strconv.FormatUint((h1+uint64(i)*h2)%f.bits, 10)
+	}
+	return positions
+}
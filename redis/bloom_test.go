@@ -0,0 +1,72 @@
+package redis
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	ctx := context.Background()
+	filter, err := NewBloomFilter("test:bloom", 1000, 0.01)
+	require.NoError(t, err)
+	require.Equal(t, uint64(9586), filter.Bits())
+	require.Equal(t, 7, filter.Hashes())
+	defer filter.ResetCtx(ctx)
+
+	added, err := filter.AddCtx(ctx, "item-0")
+	require.NoError(t, err)
+	require.True(t, added)
+	added, err = filter.AddCtx(ctx, "item-0")
+	require.NoError(t, err)
+	require.False(t, added)
+
+	for i := 1; i < 1000; i++ {
+		_, err := filter.AddCtx(ctx, "item-"+strconv.Itoa(i))
+		require.NoError(t, err)
+	}
+	for i := 0; i < 1000; i++ {
+		exists, err := filter.ExistsCtx(ctx, "item-"+strconv.Itoa(i))
+		require.NoError(t, err)
+		require.True(t, exists)
+	}
+
+	var falsePositives int
+	for i := 0; i < 1000; i++ {
+		exists, err := filter.ExistsCtx(ctx, "absent-"+strconv.Itoa(i))
+		require.NoError(t, err)
+		if exists {
+			falsePositives++
+		}
+	}
+	require.Less(t, falsePositives, 30)
+
+	_, err = NewBloomFilter("test:bloom", 1000, 1)
+	require.ErrorIs(t, err, ErrInvalidBloomFilter)
+	_, err = NewBloomFilter("test:bloom", 1<<40, 0.01)
+	require.ErrorIs(t, err, ErrBloomFilterTooLarge)
+}
+
+func TestHyperLogLog(t *testing.T) {
+	ctx := context.Background()
+	hll := NewHyperLogLog("test:uv")
+	changed, err := hll.AddCtx(ctx, "day1", "a", "b", "c")
+	require.NoError(t, err)
+	require.True(t, changed)
+	changed, err = hll.AddCtx(ctx, "day1", "a")
+	require.NoError(t, err)
+	require.False(t, changed)
+	_, err = hll.AddCtx(ctx, "day2", "c", "d")
+	require.NoError(t, err)
+	require.True(t, mr.Exists("test:uv:day1"))
+
+	count, err := hll.CountCtx(ctx, "day1")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), count)
+
+	require.NoError(t, hll.MergeCtx(ctx, "week", "day1", "day2"))
+	count, err = hll.CountCtx(ctx, "week")
+	require.NoError(t, err)
+	require.Equal(t, int64(4), count)
+}
@@ -0,0 +1,92 @@
+package redis
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+var counterPrefix = "counter:"
+
+// SetCounterPrefix settings the prefix of the ShardedCounter keys, the default is "counter:"
+func SetCounterPrefix(prefix string) {
+	if len(prefix) > 0 && !strings.HasSuffix(prefix, ":") {
+		prefix += ":"
+	}
+	counterPrefix = prefix
+}
+
+// ShardedCounter is a counter for high write rates, the increments are spread across
+// the shard keys randomly and the shards are summed on read. In cluster mode the shards
+// are in different slots, so the writes are spread across the nodes too.
+type ShardedCounter struct {
+	Key    string
+	shards int
+}
+
+func NewShardedCounter(name string, shards int) *ShardedCounter {
+	if shards < 1 {
+		shards = 1
+	}
+	return &ShardedCounter{Key: counterPrefix + name, shards: shards}
+}
+
+func (c *ShardedCounter) shard(i int) string {
+	return c.Key + ":" + strconv.Itoa(i)
+}
+
+func (c *ShardedCounter) Incr(delta int64) error {
+	return c.IncrCtx(context.Background(), delta)
+}
+
+// IncrCtx adds delta to a random shard
+func (c *ShardedCounter) IncrCtx(ctx context.Context, delta int64) error {
+	return Client().IncrBy(ctx, c.shard(rand.Intn(c.shards)), delta).Err()
+}
+
+func (c *ShardedCounter) Value() (int64, error) {
+	return c.ValueCtx(context.Background())
+}
+
+// ValueCtx returns the sum of all the shards
+func (c *ShardedCounter) ValueCtx(ctx context.Context) (int64, error) {
+	cmds := make([]*redis.StringCmd, c.shards)
+	_, err := Client().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := range cmds {
+			cmds[i] = pipe.Get(ctx, c.shard(i))
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return 0, err
+	}
+
+	var sum int64
+	for _, cmd := range cmds {
+		n, err := cmd.Int64()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		sum += n
+	}
+	return sum, nil
+}
+
+func (c *ShardedCounter) Reset() error {
+	return c.ResetCtx(context.Background())
+}
+
+// ResetCtx deletes all the shards
+func (c *ShardedCounter) ResetCtx(ctx context.Context) error {
+	_, err := Client().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := 0; i < c.shards; i++ {
+			pipe.Del(ctx, c.shard(i))
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,186 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	RoleMaster   = "master"
+	RoleReplica  = "replica"
+	RoleSentinel = "sentinel"
+)
+
+// NodeHealth is the PING result of a redis node
+type NodeHealth struct {
+	Addr    string        `json:"addr"`
+	Role    string        `json:"role"`
+	Latency time.Duration `json:"latency"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// PoolStats is the connection pool statistics of the client,
+// the counters are cumulative since the client created
+type PoolStats struct {
+	Hits       uint32 `json:"hits"`
+	Misses     uint32 `json:"misses"`
+	Timeouts   uint32 `json:"timeouts"`
+	TotalConns uint32 `json:"totalConns"`
+	IdleConns  uint32 `json:"idleConns"`
+	StaleConns uint32 `json:"staleConns"`
+}
+
+// HealthStatus is the result of Health, it's healthy only if every node answered the PING
+// and all the nodes are discovered
+type HealthStatus struct {
+	Healthy bool         `json:"healthy"`
+	Nodes   []NodeHealth `json:"nodes"`
+	Pool    PoolStats    `json:"pool"`
+
+	// Errors the errors of discovering the nodes, e.g. loading the cluster slots
+	Errors []string `json:"errors,omitempty"`
+}
+
+func Health() *HealthStatus {
+	return HealthCtx(context.Background())
+}
+
+// HealthCtx runs PING against every node of the client, the masters and replicas in cluster mode,
+// the master, sentinels and the replicas known by the sentinels in sentinel mode.
+// The deadline of ctx limits the whole check.
+func HealthCtx(ctx context.Context) *HealthStatus {
+	c := Client()
+	status := &HealthStatus{}
+
+	var mutex sync.Mutex
+	add := func(node NodeHealth) {
+		mutex.Lock()
+		status.Nodes = append(status.Nodes, node)
+		mutex.Unlock()
+	}
+
+	switch uc := c.UniversalClient.(type) {
+	case *redis.ClusterClient:
+		err := uc.ForEachMaster(ctx, func(ctx context.Context, shard *redis.Client) error {
+			add(ping(ctx, shard.Ping, shard.Options().Addr, RoleMaster))
+			return nil
+		})
+		if err != nil {
+			status.Errors = append(status.Errors, fmt.Sprintf("discover the masters fail: %v", err))
+		}
+		err = uc.ForEachSlave(ctx, func(ctx context.Context, shard *redis.Client) error {
+			add(ping(ctx, shard.Ping, shard.Options().Addr, RoleReplica))
+			return nil
+		})
+		if err != nil {
+			status.Errors = append(status.Errors, fmt.Sprintf("discover the replicas fail: %v", err))
+		}
+	case *redis.Client:
+		config := c.getConfig()
+		if len(config.MasterName) == 0 {
+			add(ping(ctx, uc.Ping, uc.Options().Addr, RoleMaster))
+			break
+		}
+
+		add(ping(ctx, uc.Ping, config.MasterName, RoleMaster))
+		var replicas []string
+		listed := false
+		for _, addr := range config.Addrs {
+			sentinel := redis.NewSentinelClient(&redis.Options{
+				Addr:        addr,
+				DialTimeout: config.DialTimeout,
+				ReadTimeout: config.ReadTimeout,
+				TLSConfig:   c.tlsConfig,
+			})
+			node := ping(ctx, func(ctx context.Context) *redis.StatusCmd {
+				return redis.NewStatusResult("", sentinel.Ping(ctx).Err())
+			}, addr, RoleSentinel)
+			add(node)
+
+			// the replicas are listed by the first sentinel answered
+			if len(node.Error) == 0 && !listed {
+				addrs, err := sentinel.Slaves(ctx, config.MasterName).Result()
+				if err != nil {
+					status.Errors = append(status.Errors, fmt.Sprintf("list the replicas from the sentinel [%s] fail: %v", addr, err))
+				} else {
+					replicas, listed = replicaAddrs(addrs), true
+				}
+			}
+			_ = sentinel.Close()
+		}
+
+		for _, addr := range replicas {
+			replica := redis.NewClient(&redis.Options{
+				Addr:        addr,
+				Password:    config.Password,
+				DB:          config.DB,
+				DialTimeout: config.DialTimeout,
+				ReadTimeout: config.ReadTimeout,
+				TLSConfig:   c.tlsConfig,
+			})
+			add(ping(ctx, replica.Ping, addr, RoleReplica))
+			_ = replica.Close()
+		}
+	}
+
+	status.Healthy = len(status.Nodes) > 0 && len(status.Errors) == 0
+	for _, node := range status.Nodes {
+		if len(node.Error) > 0 {
+			status.Healthy = false
+			break
+		}
+	}
+
+	if stats := c.PoolStats(); stats != nil {
+		status.Pool = PoolStats{
+			Hits:       stats.Hits,
+			Misses:     stats.Misses,
+			Timeouts:   stats.Timeouts,
+			TotalConns: stats.TotalConns,
+			IdleConns:  stats.IdleConns,
+			StaleConns: stats.StaleConns,
+		}
+	}
+	return status
+}
+
+func ping(ctx context.Context, fn func(ctx context.Context) *redis.StatusCmd, addr, role string) NodeHealth {
+	node := NodeHealth{Addr: addr, Role: role}
+	start := time.Now()
+	err := fn(ctx).Err()
+	node.Latency = time.Since(start)
+	if err != nil {
+		node.Error = err.Error()
+	}
+	return node
+}
+
+// replicaAddrs returns the addresses of the replicas in the result of SENTINEL REPLICAS,
+// each replica is a flat list of the field names and values
+func replicaAddrs(replicas []interface{}) []string {
+	var addrs []string
+	for _, replica := range replicas {
+		fields, ok := replica.([]interface{})
+		if !ok {
+			continue
+		}
+
+		var ip, port string
+		for i := 0; i+1 < len(fields); i += 2 {
+			switch fields[i] {
+			case "ip":
+				ip, _ = fields[i+1].(string)
+			case "port":
+				port, _ = fields[i+1].(string)
+			}
+		}
+		if len(ip) > 0 && len(port) > 0 {
+			addrs = append(addrs, net.JoinHostPort(ip, port))
+		}
+	}
+	return addrs
+}
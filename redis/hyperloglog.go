@@ -0,0 +1,66 @@
+package redis
+
+import (
+	"context"
+	"strings"
+)
+
+// HyperLogLog counts the unique elements approximately with PFADD, PFCOUNT and PFMERGE,
+// all the keys are prefixed with the prefix of the HyperLogLog
+type HyperLogLog struct {
+	prefix string
+}
+
+// NewHyperLogLog creates a HyperLogLog with the key prefix, e.g. "uv", the separator ":" is appended
+func NewHyperLogLog(prefix string) *HyperLogLog {
+	if len(prefix) > 0 && !strings.HasSuffix(prefix, ":") {
+		prefix += ":"
+	}
+	return &HyperLogLog{prefix: prefix}
+}
+
+func (h *HyperLogLog) Key(key string) string {
+	return h.prefix + key
+}
+
+func (h *HyperLogLog) keys(keys []string) []string {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = h.Key(key)
+	}
+	return prefixed
+}
+
+func (h *HyperLogLog) Add(key string, elements ...string) (bool, error) {
+	return h.AddCtx(context.Background(), key, elements...)
+}
+
+// AddCtx adds the elements to the key, returns true if the estimated cardinality changed
+func (h *HyperLogLog) AddCtx(ctx context.Context, key string, elements ...string) (bool, error) {
+	values := make([]interface{}, len(elements))
+	for i, element := range elements {
+		values[i] = element
+	}
+
+	changed, err := Client().PFAdd(ctx, h.Key(key), values...).Result()
+	return changed == 1, err
+}
+
+func (h *HyperLogLog) Count(keys ...string) (int64, error) {
+	return h.CountCtx(context.Background(), keys...)
+}
+
+// CountCtx returns the estimated cardinality of the union of the keys
+func (h *HyperLogLog) CountCtx(ctx context.Context, keys ...string) (int64, error) {
+	return Client().PFCount(ctx, h.keys(keys)...).Result()
+}
+
+func (h *HyperLogLog) Merge(dest string, keys ...string) error {
+	return h.MergeCtx(context.Background(), dest, keys...)
+}
+
+// MergeCtx merges the keys into dest, the dest is created if not exists.
+// In cluster mode the keys should be in the same slot, e.g. with hash tags.
+func (h *HyperLogLog) MergeCtx(ctx context.Context, dest string, keys ...string) error {
+	return Client().PFMerge(ctx, h.Key(dest), h.keys(keys)...).Err()
+}
@@ -0,0 +1,192 @@
+package redis
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"strings"
+)
+
+// ScoreMode is how the submitted score is applied to the current score of the member
+type ScoreMode int
+
+const (
+	// ScoreReplace the submitted score replaces the current one
+	ScoreReplace ScoreMode = iota
+	// ScoreMax the submitted score is kept only if it's higher than the current one
+	ScoreMax
+	// ScoreIncrement the submitted score is added to the current one
+	ScoreIncrement
+)
+
+var (
+	leaderboardPrefix = "leaderboard:"
+
+	// luaScoreMax updates the score only if it's higher, returns the score after updated
+	luaScoreMax = redis.NewScript(`local current = redis.call("zscore", KEYS[1], ARGV[2])
+if current and tonumber(current) >= tonumber(ARGV[1]) then return current end
+redis.call("zadd", KEYS[1], ARGV[1], ARGV[2])
+return ARGV[1]`)
+)
+
+// SetLeaderboardPrefix settings the prefix of the leaderboard keys, the default is "leaderboard:"
+func SetLeaderboardPrefix(prefix string) {
+	if len(prefix) > 0 && !strings.HasSuffix(prefix, ":") {
+		prefix += ":"
+	}
+	leaderboardPrefix = prefix
+}
+
+// Entry is a member of the leaderboard, the members with the same score have the same Rank,
+// and the next rank is skipped, e.g. 1, 2, 2, 4
+type Entry struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+	Rank   int64   `json:"rank"`
+}
+
+// Leaderboard ranks the members by score in descending order with a sorted set
+type Leaderboard struct {
+	Key  string
+	mode ScoreMode
+}
+
+func NewLeaderboard(name string, mode ScoreMode) *Leaderboard {
+	return &Leaderboard{Key: leaderboardPrefix + name, mode: mode}
+}
+
+func (l *Leaderboard) Submit(member string, score float64) (float64, error) {
+	return l.SubmitCtx(context.Background(), member, score)
+}
+
+// SubmitCtx updates the score of the member by the mode of the leaderboard, returns the score after updated
+func (l *Leaderboard) SubmitCtx(ctx context.Context, member string, score float64) (float64, error) {
+	switch l.mode {
+	case ScoreMax:
+		return luaScoreMax.Run(ctx, Client(), []string{l.Key}, formatScore(score), member).Float64()
+	case ScoreIncrement:
+		return Client().ZIncrBy(ctx, l.Key, score, member).Result()
+	default:
+		return score, Client().ZAdd(ctx, l.Key, &redis.Z{Score: score, Member: member}).Err()
+	}
+}
+
+func (l *Leaderboard) Entry(member string) (*Entry, error) {
+	return l.EntryCtx(context.Background(), member)
+}
+
+// EntryCtx returns the score and rank of the member, nil if the member is absent
+func (l *Leaderboard) EntryCtx(ctx context.Context, member string) (*Entry, error) {
+	score, err := Client().ZScore(ctx, l.Key, member).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	rank, err := l.rank(ctx, score)
+	if err != nil {
+		return nil, err
+	}
+	return &Entry{Member: member, Score: score, Rank: rank}, nil
+}
+
+func (l *Leaderboard) Page(page, size int64) ([]Entry, error) {
+	return l.PageCtx(context.Background(), page, size)
+}
+
+// PageCtx returns the entries of the page, the page starts from 1
+func (l *Leaderboard) PageCtx(ctx context.Context, page, size int64) ([]Entry, error) {
+	if page < 1 || size < 1 {
+		return nil, nil
+	}
+	start := (page - 1) * size
+	return l.entries(ctx, start, start+size-1)
+}
+
+func (l *Leaderboard) Around(member string, n int64) ([]Entry, error) {
+	return l.AroundCtx(context.Background(), member, n)
+}
+
+// AroundCtx returns the entries ranked around the member, n entries above and below it at most.
+// Returns nil if the member is absent.
+func (l *Leaderboard) AroundCtx(ctx context.Context, member string, n int64) ([]Entry, error) {
+	pos, err := Client().ZRevRank(ctx, l.Key, member).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	start := pos - n
+	if start < 0 {
+		start = 0
+	}
+	return l.entries(ctx, start, pos+n)
+}
+
+func (l *Leaderboard) Remove(members ...string) error {
+	return l.RemoveCtx(context.Background(), members...)
+}
+
+// RemoveCtx removes the members from the leaderboard
+func (l *Leaderboard) RemoveCtx(ctx context.Context, members ...string) error {
+	values := make([]interface{}, len(members))
+	for i, member := range members {
+		values[i] = member
+	}
+	return Client().ZRem(ctx, l.Key, values...).Err()
+}
+
+func (l *Leaderboard) Count() (int64, error) {
+	return l.CountCtx(context.Background())
+}
+
+// CountCtx returns the number of members
+func (l *Leaderboard) CountCtx(ctx context.Context) (int64, error) {
+	return Client().ZCard(ctx, l.Key).Result()
+}
+
+func (l *Leaderboard) Reset() error {
+	return l.ResetCtx(context.Background())
+}
+
+// ResetCtx deletes the leaderboard
+func (l *Leaderboard) ResetCtx(ctx context.Context) error {
+	return Client().Del(ctx, l.Key).Err()
+}
+
+// entries returns the entries in the positions from start to stop
+func (l *Leaderboard) entries(ctx context.Context, start, stop int64) ([]Entry, error) {
+	zs, err := Client().ZRevRangeWithScores(ctx, l.Key, start, stop).Result()
+	if err != nil || len(zs) == 0 {
+		return nil, err
+	}
+
+	entries := make([]Entry, len(zs))
+	for i, z := range zs {
+		entries[i] = Entry{Member: z.Member.(string), Score: z.Score}
+		switch {
+		case i > 0 && z.Score == zs[i-1].Score:
+			entries[i].Rank = entries[i-1].Rank
+		case i > 0:
+			entries[i].Rank = start + int64(i) + 1
+		default:
+			// the first entry may tie with the entries of the previous page
+			if entries[i].Rank, err = l.rank(ctx, z.Score); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return entries, nil
+}
+
+// rank returns the number of members with a higher score plus one
+func (l *Leaderboard) rank(ctx context.Context, score float64) (int64, error) {
+	higher, err := Client().ZCount(ctx, l.Key, "("+formatScore(score), "+inf").Result()
+	return higher + 1, err
+}
+
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
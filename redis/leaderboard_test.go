@@ -0,0 +1,80 @@
+package redis
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestLeaderboard(t *testing.T) {
+	ctx := context.Background()
+	SetLeaderboardPrefix("test:leaderboard")
+	defer SetLeaderboardPrefix("leaderboard:")
+
+	board := NewLeaderboard("max", ScoreMax)
+	require.Equal(t, "test:leaderboard:max", board.Key)
+	defer board.ResetCtx(ctx)
+	for member, score := range map[string]float64{"a": 100, "b": 90, "c": 90, "d": 80, "e": 70} {
+		_, err := board.SubmitCtx(ctx, member, score)
+		require.NoError(t, err)
+	}
+	score, err := board.SubmitCtx(ctx, "a", 50)
+	require.NoError(t, err)
+	require.Equal(t, float64(100), score)
+	score, err = board.SubmitCtx(ctx, "e", 75)
+	require.NoError(t, err)
+	require.Equal(t, float64(75), score)
+
+	page, err := board.PageCtx(ctx, 1, 2)
+	require.NoError(t, err)
+	require.Equal(t, []Entry{{"a", 100, 1}, {"c", 90, 2}}, page)
+	page, err = board.PageCtx(ctx, 2, 2)
+	require.NoError(t, err)
+	require.Equal(t, []Entry{{"b", 90, 2}, {"d", 80, 4}}, page)
+
+	around, err := board.AroundCtx(ctx, "d", 1)
+	require.NoError(t, err)
+	require.Equal(t, []Entry{{"b", 90, 2}, {"d", 80, 4}, {"e", 75, 5}}, around)
+	around, err = board.AroundCtx(ctx, "none", 1)
+	require.NoError(t, err)
+	require.Nil(t, around)
+
+	entry, err := board.EntryCtx(ctx, "b")
+	require.NoError(t, err)
+	require.Equal(t, &Entry{"b", 90, 2}, entry)
+
+	incr := NewLeaderboard("incr", ScoreIncrement)
+	defer incr.ResetCtx(ctx)
+	_, err = incr.SubmitCtx(ctx, "a", 10)
+	require.NoError(t, err)
+	score, err = incr.SubmitCtx(ctx, "a", 5)
+	require.NoError(t, err)
+	require.Equal(t, float64(15), score)
+
+	replace := NewLeaderboard("replace", ScoreReplace)
+	defer replace.ResetCtx(ctx)
+	_, err = replace.SubmitCtx(ctx, "a", 10)
+	require.NoError(t, err)
+	_, err = replace.SubmitCtx(ctx, "a", 5)
+	require.NoError(t, err)
+	entry, err = replace.EntryCtx(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, float64(5), entry.Score)
+}
+
+func TestShardedCounter(t *testing.T) {
+	ctx := context.Background()
+	counter := NewShardedCounter("test:visits", 8)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, counter.IncrCtx(ctx, 2))
+	}
+
+	value, err := counter.ValueCtx(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(200), value)
+
+	require.NoError(t, counter.ResetCtx(ctx))
+	value, err = counter.ValueCtx(ctx)
+	require.NoError(t, err)
+	require.Zero(t, value)
+}
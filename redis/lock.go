@@ -1,11 +1,12 @@
 package redis
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"github.com/go-redis/redis"
+	"github.com/go-redis/redis/v8"
 	"github.com/transerver/commons/logger"
 	"io"
 	"strconv"
@@ -17,6 +18,17 @@ var (
 	luaRefresh = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`)
 	luaRelease = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`)
 	luaPTTL    = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pttl", KEYS[1]) else return -3 end`)
+	luaObtain  = redis.NewScript(`local ok
+if tonumber(ARGV[2]) > 0 then
+	ok = redis.call("set", KEYS[1], ARGV[1], "px", ARGV[2], "nx")
+else
+	ok = redis.call("set", KEYS[1], ARGV[1], "nx")
+end
+if not ok then return 0 end
+redis.call("set", KEYS[2], ARGV[3], "nx")
+local token = redis.call("incr", KEYS[2])
+redis.call("pexpire", KEYS[2], ARGV[4])
+return token`)
 
 	// ErrNotObtained is returned when a lock cannot be obtained.
 	ErrNotObtained = errors.New("redislock: not obtained")
@@ -28,10 +40,37 @@ var (
 	tmpMu sync.Mutex
 )
 
+const (
+	// watchDogRatio the watchdog refreshes the lock every ttl/watchDogRatio
+	watchDogRatio = 3
+
+	// watchDogMinBackoff the first backoff of retrying the failed refresh, it's doubled for each retry
+	watchDogMinBackoff = 20 * time.Millisecond
+
+	// fencingSuffix the suffix of the key which holds the fencing token counter
+	fencingSuffix = ":fencing"
+
+	// fencingTTL the counter expires if the lock isn't obtained for so long, it's much longer than any
+	// write guarded by the lock, and the counter restarts from the current unix milliseconds
+	// so the tokens are still increasing after it expired
+	fencingTTL = 7 * 24 * time.Hour
+)
+
 type Lock struct {
 	Key    string
 	value  string
 	Locked bool
+
+	// Token is the fencing token of the lock, it's increased monotonically
+	// every time the lock is obtained, it's zero if the lock is not obtained.
+	// Pass it along with the writes guarded by the lock, so the stale
+	// writes can be rejected, see dbs.Database.ExecFenced
+	Token int64
+
+	ttl    time.Duration
+	cancel context.CancelFunc
+	done   chan struct{}
+	mutex  sync.Mutex
 }
 
 func randomToken() (string, error) {
@@ -48,24 +87,40 @@ func randomToken() (string, error) {
 	return base64.RawURLEncoding.EncodeToString(tmp), nil
 }
 
-func (c *redisClient) obtain(key string, ttl time.Duration) (*Lock, error) {
+func (c *redisClient) obtain(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
 	token, err := randomToken()
 	if err != nil {
 		return nil, err
 	}
 
-	locked, err := c.SetNX(key, token, ttl).Result()
+	ttlVal := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+	seed := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	fencingTTLVal := strconv.FormatInt(int64(fencingTTL/time.Millisecond), 10)
+	fencing, err := luaObtain.Run(ctx, c, []string{key, FencingKey(key)}, token, ttlVal, seed, fencingTTLVal).Int64()
 	if err != nil {
 		return nil, err
 	}
-	return &Lock{Key: key, value: token, Locked: locked}, nil
+	return &Lock{Key: key, value: token, Locked: fencing > 0, Token: fencing, ttl: ttl}, nil
+}
+
+// FencingKey returns the key of the fencing token counter of the lock key,
+// the counter expires after the lock isn't obtained for 7 days.
+// In cluster mode the lock key should contain a hash tag, e.g. "{order:1}",
+// so the lock and the counter are in the same slot.
+func FencingKey(key string) string {
+	return key + fencingSuffix
 }
 
 func Obtain(ttl time.Duration, format string, v ...interface{}) (*Lock, error) {
+	return ObtainCtx(context.Background(), ttl, format, v...)
+}
+
+// ObtainCtx obtains the lock, the deadline of ctx is applied to the redis commands
+func ObtainCtx(ctx context.Context, ttl time.Duration, format string, v ...interface{}) (*Lock, error) {
 	if len(v) > 0 {
 		format = fmt.Sprintf(format, v...)
 	}
-	lock, err := Client().obtain(format, ttl)
+	lock, err := Client().obtain(ctx, format, ttl)
 	if err != nil {
 		logger.Errorf("redislock:obtain %+v", err)
 	}
@@ -74,7 +129,15 @@ func Obtain(ttl time.Duration, format string, v ...interface{}) (*Lock, error) {
 
 // TTL returns the remaining time-to-live. Returns 0 if the lock has expired.
 func (l *Lock) TTL() (time.Duration, error) {
-	res, err := luaPTTL.Run(client, []string{l.Key}, l.value).Result()
+	return l.TTLCtx(context.Background())
+}
+
+func (l *Lock) TTLCtx(ctx context.Context) (time.Duration, error) {
+	return pttl(luaPTTL.Run(ctx, client, []string{l.Key}, l.value).Result())
+}
+
+// pttl converts the result of the pttl scripts to time.Duration
+func pttl(res interface{}, err error) (time.Duration, error) {
 	if err == redis.Nil {
 		return 0, nil
 	} else if err != nil {
@@ -90,8 +153,12 @@ func (l *Lock) TTL() (time.Duration, error) {
 // Refresh extends the lock with a new TTL.
 // May return ErrNotObtained if refresh is unsuccessful.
 func (l *Lock) Refresh(ttl time.Duration) error {
+	return l.RefreshCtx(context.Background(), ttl)
+}
+
+func (l *Lock) RefreshCtx(ctx context.Context, ttl time.Duration) error {
 	ttlVal := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
-	status, err := luaRefresh.Run(client, []string{l.Key}, l.value, ttlVal).Result()
+	status, err := luaRefresh.Run(ctx, client, []string{l.Key}, l.value, ttlVal).Result()
 	if err != nil {
 		return err
 	} else if status == int64(1) {
@@ -100,10 +167,105 @@ func (l *Lock) Refresh(ttl time.Duration) error {
 	return ErrNotObtained
 }
 
-// Release manually releases the lock.
+// WatchDog starts a background goroutine which refreshes the lock
+// every ttl/3 with the ttl it was obtained with, until Release is called.
+// The returned context is cancelled when the lock is released or the
+// ownership of the lock is lost, i.e. the lock is held by another one,
+// or the refresh keeps failing until the lock expired.
+func (l *Lock) WatchDog(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	wctx, cancel := context.WithCancel(ctx)
+	if !l.Locked || l.ttl <= 0 {
+		cancel()
+		return wctx
+	}
+
+	if l.cancel != nil {
+		l.cancel()
+		<-l.done
+	}
+	l.cancel = cancel
+	l.done = make(chan struct{})
+	go l.watch(wctx, cancel, l.done)
+	return wctx
+}
+
+// watch refreshes the lock until ctx is done. The transient errors of the refresh are retried with backoff
+// until the lock is about to expire, the lock is lost immediately if it's not held by l anymore
+func (l *Lock) watch(ctx context.Context, cancel context.CancelFunc, done chan struct{}) {
+	defer close(done)
+	defer cancel()
+
+	interval := l.ttl / watchDogRatio
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	expiry := time.Now().Add(l.ttl)
+	backoff := watchDogMinBackoff
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		start := time.Now()
+		rctx, rcancel := context.WithDeadline(ctx, expiry)
+		err := l.RefreshCtx(rctx, l.ttl)
+		rcancel()
+		switch {
+		case err == nil:
+			expiry = start.Add(l.ttl)
+			backoff = watchDogMinBackoff
+			timer.Reset(interval)
+			continue
+		case ctx.Err() != nil:
+			return
+		case errors.Is(err, ErrNotObtained):
+			logger.Errorf("redislock:watchdog lost the lock, key: %s, %+v", l.Key, err)
+			return
+		}
+
+		if time.Now().Add(backoff).After(expiry) {
+			logger.Errorf("redislock:watchdog lost the lock, key: %s, the lock expired while retrying: %+v", l.Key, err)
+			return
+		}
+		logger.Warnf("redislock:watchdog refresh fail, retry in %s, key: %s, %+v", backoff, l.Key, err)
+		timer.Reset(backoff)
+		if backoff *= 2; backoff > interval {
+			backoff = interval
+		}
+	}
+}
+
+func (l *Lock) stopWatchDog() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.cancel == nil {
+		return
+	}
+	l.cancel()
+	<-l.done
+	l.cancel = nil
+	l.done = nil
+}
+
+// Release manually releases the lock and stops the watchdog.
 // May return ErrLockNotHeld.
 func (l *Lock) Release() error {
-	res, err := luaRelease.Run(client, []string{l.Key}, l.value).Result()
+	return l.ReleaseCtx(context.Background())
+}
+
+func (l *Lock) ReleaseCtx(ctx context.Context) error {
+	l.stopWatchDog()
+	res, err := luaRelease.Run(ctx, client, []string{l.Key}, l.value).Result()
 	if err == redis.Nil {
 		return ErrLockNotHeld
 	} else if err != nil {
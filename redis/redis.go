@@ -1,8 +1,9 @@
 package redis
 
 import (
+	"context"
 	"crypto/tls"
-	"github.com/go-redis/redis"
+	"github.com/go-redis/redis/v8"
 	"github.com/transerver/commons/configs"
 	"github.com/transerver/commons/logger"
 	"sync"
@@ -10,18 +11,21 @@ import (
 
 const Nil = redis.Nil
 
-var client = &redisClient{}
+var client = &redisClient{Logger: logger.NewLogger(logger.WithPrefix("REDIS"))}
 
 type redisClient struct {
 	redis.UniversalClient
 
-	onConnected func(*redis.Conn) error
+	onConnected func(context.Context, *redis.Conn) error
 	tlsConfig   *tls.Config
 	config      *configs.RedisConfig
+	hook        RedisHook
+	hookMutex   sync.RWMutex
 	mutex       sync.Mutex
+	Logger      *logger.Logger
 }
 
-func RegisterOnConnected(fn func(conn *redis.Conn) error) {
+func RegisterOnConnected(fn func(ctx context.Context, conn *redis.Conn) error) {
 	client.onConnected = fn
 }
 
@@ -33,6 +37,31 @@ func SetTLSConfig(config *tls.Config) {
 	client.tlsConfig = config
 }
 
+// SetHook settings the RedisHook of all the clients, the hook's logger is replaced with the client's
+func SetHook(hook RedisHook) {
+	if hook != nil {
+		hook.SetLogger(client.Logger)
+	}
+	client.setHook(hook)
+}
+
+func SetLoggerHook() {
+	client.setHook(&RedisLoggerHook{Logger: client.Logger})
+}
+
+// setHook settings the hook, it's safe to call while the commands are running
+func (c *redisClient) setHook(hook RedisHook) {
+	c.hookMutex.Lock()
+	defer c.hookMutex.Unlock()
+	c.hook = hook
+}
+
+func (c *redisClient) getHook() RedisHook {
+	c.hookMutex.RLock()
+	defer c.hookMutex.RUnlock()
+	return c.hook
+}
+
 func Client() *redisClient {
 	if client.UniversalClient != nil {
 		return client
@@ -46,6 +75,14 @@ func Client() *redisClient {
 	}
 
 	config := client.getConfig()
+	uc := newUniversalClient(config)
+	client.UniversalClient = uc
+	return client
+}
+
+// newUniversalClient creates the redis.UniversalClient with config,
+// the registered OnConnected function, tls.Config and RedisHook are used too
+func newUniversalClient(config *configs.RedisConfig) redis.UniversalClient {
 	uc := redis.NewUniversalClient(&redis.UniversalOptions{
 		Addrs:              config.Addrs,
 		DB:                 config.DB,
@@ -70,8 +107,8 @@ func Client() *redisClient {
 		RouteRandomly:      config.RouteRandomly,
 		MasterName:         config.MasterName,
 	})
-	client.UniversalClient = uc
-	return client
+	uc.AddHook(hookAdapter{})
+	return uc
 }
 
 func (c *redisClient) getConfig() *configs.RedisConfig {
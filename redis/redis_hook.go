@@ -0,0 +1,247 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/transerver/commons/logger"
+	"strings"
+	"time"
+)
+
+// maxLoggedArgLen the arguments longer than it are truncated in logs
+const maxLoggedArgLen = 64
+
+// secretConfigs the parameters of CONFIG SET whose values are redacted in logs
+var secretConfigs = map[string]struct{}{
+	"requirepass":              {},
+	"masterauth":               {},
+	"tls-key-file-pass":        {},
+	"tls-client-key-file-pass": {},
+}
+
+type RedisHook interface {
+	Before(ctx context.Context, cmd redis.Cmder) (context.Context, error)
+	After(ctx context.Context, cmd redis.Cmder) (context.Context, error)
+	BeforePipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error)
+	AfterPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error)
+	OnError(ctx context.Context, err error, cmds ...redis.Cmder)
+	SetLogger(logger *logger.Logger)
+}
+
+type redisStartTimeKey struct{}
+
+// RedisLoggerHook logs every command at debug level and the failed commands at error level
+type RedisLoggerHook struct {
+	Logger *logger.Logger
+
+	// Redacted the commands whose arguments are redacted in logs. The credentials of AUTH, HELLO ... AUTH,
+	// MIGRATE ... AUTH/AUTH2, CONFIG SET requirepass/masterauth and ACL SETUSER are always redacted
+	Redacted map[string]struct{}
+}
+
+func (h *RedisLoggerHook) Before(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartTimeKey{}, time.Now()), nil
+}
+
+func (h *RedisLoggerHook) After(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if h.Logger.Level > logger.DebugLevel {
+		return ctx, nil
+	}
+
+	if startTime, ok := ctx.Value(redisStartTimeKey{}).(time.Time); ok {
+		h.Logger.Debugf("CMD: %s, Took: %s", h.format(cmd), time.Since(startTime))
+	} else {
+		h.Logger.Debugf("CMD: %s", h.format(cmd))
+	}
+	return ctx, nil
+}
+
+func (h *RedisLoggerHook) BeforePipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartTimeKey{}, time.Now()), nil
+}
+
+func (h *RedisLoggerHook) AfterPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	if h.Logger.Level > logger.DebugLevel {
+		return ctx, nil
+	}
+
+	if startTime, ok := ctx.Value(redisStartTimeKey{}).(time.Time); ok {
+		h.Logger.Debugf("PIPELINE: %s, Took: %s", h.formatAll(cmds), time.Since(startTime))
+	} else {
+		h.Logger.Debugf("PIPELINE: %s", h.formatAll(cmds))
+	}
+	return ctx, nil
+}
+
+func (h *RedisLoggerHook) OnError(ctx context.Context, err error, cmds ...redis.Cmder) {
+	if startTime, ok := ctx.Value(redisStartTimeKey{}).(time.Time); ok {
+		h.Logger.Errorf("CMD: %s, Took: %s, Error: %+v", h.formatAll(cmds), time.Since(startTime), err)
+	} else {
+		h.Logger.Errorf("CMD: %s, Error: %+v", h.formatAll(cmds), err)
+	}
+}
+
+func (h *RedisLoggerHook) SetLogger(logger *logger.Logger) {
+	h.Logger = logger
+}
+
+func (h *RedisLoggerHook) formatAll(cmds []redis.Cmder) string {
+	formatted := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		formatted[i] = h.format(cmd)
+	}
+	return strings.Join(formatted, "; ")
+}
+
+// format returns the command and its arguments, the arguments of the redacted commands
+// and the credentials are replaced and the long arguments are truncated
+func (h *RedisLoggerHook) format(cmd redis.Cmder) string {
+	name := cmd.Name()
+	args := cmd.Args()
+
+	_, redacted := h.Redacted[name]
+	secrets := secretArgs(name, args)
+
+	var sb strings.Builder
+	sb.WriteString(name)
+	for i := 1; i < len(args); i++ {
+		sb.WriteByte(' ')
+		if redacted || secrets[i] {
+			sb.WriteString("[***]")
+			continue
+		}
+
+		s := fmt.Sprint(args[i])
+		if len(s) > maxLoggedArgLen {
+			s = s[:maxLoggedArgLen] + "..."
+		}
+		sb.WriteString(s)
+	}
+	return sb.String()
+}
+
+// secretArgs returns the indexes of the arguments which are credentials
+func secretArgs(name string, args []interface{}) map[int]bool {
+	secrets := make(map[int]bool)
+	arg := func(i int) string {
+		return strings.ToLower(fmt.Sprint(args[i]))
+	}
+
+	switch name {
+	case "auth":
+		for i := 1; i < len(args); i++ {
+			secrets[i] = true
+		}
+	case "hello", "migrate":
+		// HELLO protover AUTH username password, MIGRATE ... AUTH password | AUTH2 username password
+		for i := 1; i < len(args); i++ {
+			switch arg(i) {
+			case "auth":
+				secrets[i+1] = true
+				if name == "hello" {
+					secrets[i+2] = true
+				}
+			case "auth2":
+				secrets[i+1], secrets[i+2] = true, true
+			}
+		}
+	case "config":
+		if len(args) > 1 && arg(1) == "set" {
+			for i := 2; i+1 < len(args); i += 2 {
+				if _, ok := secretConfigs[arg(i)]; ok {
+					secrets[i+1] = true
+				}
+			}
+		}
+	case "acl":
+		// the password rules, e.g. >password, <password, #hash and !hash
+		if len(args) > 2 && arg(1) == "setuser" {
+			for i := 3; i < len(args); i++ {
+				if rule := arg(i); len(rule) > 0 && strings.ContainsRune("><#!", rune(rule[0])) {
+					secrets[i] = true
+				}
+			}
+		}
+	}
+	return secrets
+}
+
+// cmdErr returns the error of the command, redis.Nil is not an error
+func cmdErr(err error) error {
+	if err == redis.Nil {
+		return nil
+	}
+	return err
+}
+
+// hookAdapter adapts the registered RedisHook to redis.Hook,
+// the errors of the RedisHook are passed to OnError and never fail the commands
+type hookAdapter struct{}
+
+func (hookAdapter) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	hook := client.getHook()
+	if hook == nil {
+		return ctx, nil
+	}
+
+	hctx, err := hook.Before(ctx, cmd)
+	if hctx == nil {
+		hctx = ctx
+	}
+	if err != nil {
+		hook.OnError(hctx, err, cmd)
+	}
+	return hctx, nil
+}
+
+func (hookAdapter) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	hook := client.getHook()
+	if hook == nil {
+		return nil
+	}
+
+	if err := cmdErr(cmd.Err()); err != nil {
+		hook.OnError(ctx, err, cmd)
+	} else if _, err = hook.After(ctx, cmd); err != nil {
+		hook.OnError(ctx, err, cmd)
+	}
+	return nil
+}
+
+func (hookAdapter) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	hook := client.getHook()
+	if hook == nil {
+		return ctx, nil
+	}
+
+	hctx, err := hook.BeforePipeline(ctx, cmds)
+	if hctx == nil {
+		hctx = ctx
+	}
+	if err != nil {
+		hook.OnError(hctx, err, cmds...)
+	}
+	return hctx, nil
+}
+
+func (hookAdapter) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	hook := client.getHook()
+	if hook == nil {
+		return nil
+	}
+
+	var err error
+	for _, cmd := range cmds {
+		if cerr := cmdErr(cmd.Err()); cerr != nil {
+			err = cerr
+			break
+		}
+	}
+	if err != nil {
+		hook.OnError(ctx, err, cmds...)
+	} else if _, err = hook.AfterPipeline(ctx, cmds); err != nil {
+		hook.OnError(ctx, err, cmds...)
+	}
+	return nil
+}
@@ -1,16 +1,21 @@
 package redis
 
 import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/require"
-	"github.com/transerver/commons/configs"
+	"github.com/transerver/commons/internal/redistest"
 	"github.com/transerver/commons/logger"
 	"testing"
 	"time"
 )
 
-func init() {
-	configs.SetConfigFile("../configs/config.toml")
-	_ = configs.ReadConfig()
+var mr *miniredis.Miniredis
+
+func TestMain(m *testing.M) {
+	mr = redistest.Start(SetConfig)
+	redistest.Run(m, mr)
 }
 
 func TestLock(t *testing.T) {
@@ -33,3 +38,343 @@ func TestLock(t *testing.T) {
 
 	logger.Infof("end obtain, Key: %s, Token: %s", lock.Key, lock.value)
 }
+
+func TestLockCtx(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := ObtainCtx(ctx, time.Second*5, "test %s", "obtain:ctx")
+	require.ErrorIs(t, err, context.Canceled)
+	require.False(t, mr.Exists("test obtain:ctx"))
+
+	lock, err := ObtainCtx(context.Background(), time.Second*5, "test %s", "obtain:ctx")
+	require.NoError(t, err)
+	require.True(t, lock.Locked)
+	require.ErrorIs(t, lock.ReleaseCtx(ctx), context.Canceled)
+	require.True(t, mr.Exists(lock.Key))
+	require.NoError(t, lock.ReleaseCtx(context.Background()))
+}
+
+func TestLockWatchDog(t *testing.T) {
+	lock, err := Obtain(time.Millisecond*300, "test %s", "watchdog")
+	require.NoError(t, err)
+	require.True(t, lock.Locked)
+
+	ctx := lock.WatchDog(context.Background())
+	mr.FastForward(time.Millisecond * 200)
+	<-time.After(time.Millisecond * 150)
+	ttl, err := lock.TTL()
+	require.NoError(t, err)
+	require.Greater(t, ttl, time.Millisecond*200)
+	require.NoError(t, ctx.Err())
+
+	require.NoError(t, lock.Release())
+	require.Error(t, ctx.Err())
+}
+
+func TestLockWatchDogLost(t *testing.T) {
+	lock, err := Obtain(time.Millisecond*300, "test %s", "watchdog:lost")
+	require.NoError(t, err)
+	require.True(t, lock.Locked)
+
+	ctx := lock.WatchDog(context.Background())
+	mr.Del(lock.Key)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("watchdog doesn't notify the lock lost")
+	}
+	require.ErrorIs(t, lock.Release(), ErrLockNotHeld)
+}
+
+func TestLockWatchDogRetry(t *testing.T) {
+	lock, err := Obtain(time.Millisecond*600, "test %s", "watchdog:retry")
+	require.NoError(t, err)
+	require.True(t, lock.Locked)
+
+	ctx := lock.WatchDog(context.Background())
+	// the refreshes fail for a while but the lock isn't expired yet
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	<-time.After(time.Millisecond * 300)
+	require.NoError(t, ctx.Err())
+	mr.SetError("")
+
+	<-time.After(time.Millisecond * 300)
+	require.NoError(t, ctx.Err())
+	ttl, err := lock.TTL()
+	require.NoError(t, err)
+	require.Greater(t, ttl, time.Duration(0))
+	require.NoError(t, lock.Release())
+}
+
+func TestLockWatchDogExpired(t *testing.T) {
+	lock, err := Obtain(time.Millisecond*300, "test %s", "watchdog:expired")
+	require.NoError(t, err)
+	require.True(t, lock.Locked)
+
+	ctx := lock.WatchDog(context.Background())
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	defer mr.SetError("")
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("watchdog doesn't give up after the lock expired")
+	}
+}
+
+func TestReentrantLock(t *testing.T) {
+	lock, err := ObtainReentrant("owner", time.Second*5, "test %s", "reentrant")
+	require.NoError(t, err)
+	require.True(t, lock.Locked)
+	require.Equal(t, int64(1), lock.Count)
+
+	again, err := ObtainReentrant("owner", time.Second*5, "test %s", "reentrant")
+	require.NoError(t, err)
+	require.True(t, again.Locked)
+	require.Equal(t, int64(2), again.Count)
+
+	other, err := ObtainReentrant("other", time.Second*5, "test %s", "reentrant")
+	require.NoError(t, err)
+	require.False(t, other.Locked)
+	require.ErrorIs(t, other.Release(), ErrLockNotHeld)
+
+	require.NoError(t, lock.Refresh(time.Second*10))
+	ttl, err := lock.TTL()
+	require.NoError(t, err)
+	require.Equal(t, time.Second*10, ttl)
+
+	require.NoError(t, again.Release())
+	require.Equal(t, int64(1), again.Count)
+	require.True(t, mr.Exists(lock.Key))
+	require.NoError(t, lock.Release())
+	require.False(t, lock.Locked)
+	require.False(t, mr.Exists(lock.Key))
+}
+
+func TestRWLock(t *testing.T) {
+	r1, err := ObtainRead(time.Second*5, "test %s", "rwlock")
+	require.NoError(t, err)
+	require.True(t, r1.Locked)
+	r2, err := ObtainRead(time.Second*5, "test %s", "rwlock")
+	require.NoError(t, err)
+	require.True(t, r2.Locked)
+
+	w, err := ObtainWrite(time.Second*5, "test %s", "rwlock")
+	require.NoError(t, err)
+	require.False(t, w.Locked)
+
+	require.NoError(t, r1.Release())
+	require.NoError(t, r2.Release())
+	require.False(t, mr.Exists(r1.Key))
+
+	w, err = ObtainWrite(time.Second*5, "test %s", "rwlock")
+	require.NoError(t, err)
+	require.True(t, w.Locked)
+	require.True(t, w.Writing())
+	r1, err = ObtainRead(time.Second*5, "test %s", "rwlock")
+	require.NoError(t, err)
+	require.False(t, r1.Locked)
+
+	ttl, err := w.TTL()
+	require.NoError(t, err)
+	require.Equal(t, time.Second*5, ttl)
+	require.NoError(t, w.Release())
+	require.ErrorIs(t, w.Release(), ErrLockNotHeld)
+}
+
+func TestRWLockReaderExpired(t *testing.T) {
+	crashed, err := ObtainRead(time.Millisecond*100, "test %s", "rwlock:expired")
+	require.NoError(t, err)
+	require.True(t, crashed.Locked)
+	r, err := ObtainRead(time.Second*5, "test %s", "rwlock:expired")
+	require.NoError(t, err)
+	require.True(t, r.Locked)
+
+	// the crashed reader expires while the other one keeps refreshing
+	<-time.After(time.Millisecond * 150)
+	require.NoError(t, r.Refresh(time.Second*5))
+	ttl, err := crashed.TTL()
+	require.NoError(t, err)
+	require.Zero(t, ttl)
+	ttl, err = r.TTL()
+	require.NoError(t, err)
+	require.Greater(t, ttl, time.Second*4)
+	require.ErrorIs(t, crashed.Refresh(time.Second), ErrNotObtained)
+
+	w, err := ObtainWrite(time.Second*5, "test %s", "rwlock:expired")
+	require.NoError(t, err)
+	require.False(t, w.Locked)
+
+	require.NoError(t, r.Release())
+	require.False(t, mr.Exists(r.Key))
+	w, err = ObtainWrite(time.Second*5, "test %s", "rwlock:expired")
+	require.NoError(t, err)
+	require.True(t, w.Locked)
+	require.NoError(t, w.Release())
+}
+
+func TestLockNoExpiry(t *testing.T) {
+	lock, err := ObtainReentrant("owner", 0, "test %s", "reentrant:persist")
+	require.NoError(t, err)
+	require.True(t, lock.Locked)
+	require.True(t, mr.Exists(lock.Key))
+	require.Zero(t, mr.TTL(lock.Key))
+	require.NoError(t, lock.Release())
+
+	w, err := ObtainWrite(0, "test %s", "rwlock:persist")
+	require.NoError(t, err)
+	require.True(t, w.Locked)
+	require.True(t, mr.Exists(w.Key))
+	require.Zero(t, mr.TTL(w.Key))
+	require.NoError(t, w.Release())
+
+	r1, err := ObtainRead(0, "test %s", "rwlock:persist")
+	require.NoError(t, err)
+	require.True(t, r1.Locked)
+	r2, err := ObtainRead(time.Millisecond*100, "test %s", "rwlock:persist")
+	require.NoError(t, err)
+	require.True(t, r2.Locked)
+	require.Zero(t, mr.TTL(r1.Key))
+
+	// the reader without expiry is kept after the other one expired
+	<-time.After(time.Millisecond * 150)
+	w, err = ObtainWrite(time.Second, "test %s", "rwlock:persist")
+	require.NoError(t, err)
+	require.False(t, w.Locked)
+	require.ErrorIs(t, r2.Release(), ErrLockNotHeld)
+	require.NoError(t, r1.Release())
+	require.False(t, mr.Exists(r1.Key))
+}
+
+func TestLockFencingToken(t *testing.T) {
+	lock, err := Obtain(time.Second*5, "test %s", "fencing")
+	require.NoError(t, err)
+	require.True(t, lock.Locked)
+	require.Greater(t, lock.Token, int64(0))
+
+	other, err := Obtain(time.Second*5, "test %s", "fencing")
+	require.NoError(t, err)
+	require.False(t, other.Locked)
+	require.Zero(t, other.Token)
+	require.NoError(t, lock.Release())
+
+	next, err := Obtain(time.Second*5, "test %s", "fencing")
+	require.NoError(t, err)
+	require.True(t, next.Locked)
+	require.Equal(t, lock.Token+1, next.Token)
+	require.Equal(t, fencingTTL, mr.TTL(FencingKey(next.Key)))
+	require.NoError(t, next.Release())
+
+	// the tokens keep increasing after the counter expired
+	mr.FastForward(fencingTTL)
+	require.False(t, mr.Exists(FencingKey(next.Key)))
+	time.Sleep(time.Millisecond * 2)
+	again, err := Obtain(time.Second*5, "test %s", "fencing")
+	require.NoError(t, err)
+	defer again.LoggedRelease()
+	require.Greater(t, again.Token, next.Token)
+}
+
+type recordHook struct {
+	RedisLoggerHook
+	after  []string
+	errors []error
+}
+
+func (h *recordHook) After(ctx context.Context, cmd goredis.Cmder) (context.Context, error) {
+	h.after = append(h.after, h.format(cmd))
+	return h.RedisLoggerHook.After(ctx, cmd)
+}
+
+func (h *recordHook) AfterPipeline(ctx context.Context, cmds []goredis.Cmder) (context.Context, error) {
+	h.after = append(h.after, h.formatAll(cmds))
+	return h.RedisLoggerHook.AfterPipeline(ctx, cmds)
+}
+
+func (h *recordHook) OnError(ctx context.Context, err error, cmds ...goredis.Cmder) {
+	h.errors = append(h.errors, err)
+	h.RedisLoggerHook.OnError(ctx, err, cmds...)
+}
+
+func TestRedisHook(t *testing.T) {
+	hook := &recordHook{RedisLoggerHook: RedisLoggerHook{Redacted: map[string]struct{}{"set": {}}}}
+	SetHook(hook)
+	defer SetHook(nil)
+
+	require.NoError(t, Client().Set(context.Background(), "test:hook", "secret", 0).Err())
+	require.Equal(t, Nil, Client().Get(context.Background(), "test:hook:none").Err())
+	_, err := Client().Pipelined(context.Background(), func(pipe goredis.Pipeliner) error {
+		pipe.Incr(context.Background(), "test:hook:counter")
+		pipe.Expire(context.Background(), "test:hook:counter", time.Minute)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Error(t, Client().Incr(context.Background(), "test:hook").Err())
+
+	require.Equal(t, []string{
+		"set [***] [***]",
+		"get test:hook:none",
+		"incr test:hook:counter; expire test:hook:counter 60",
+	}, hook.after)
+	require.Len(t, hook.errors, 1)
+}
+
+func TestRedisHookCredentials(t *testing.T) {
+	hook := &RedisLoggerHook{}
+	ctx := context.Background()
+	for expected, cmd := range map[string]goredis.Cmder{
+		"auth [***] [***]":                               goredis.NewStatusCmd(ctx, "auth", "user", "pass"),
+		"hello 3 AUTH [***] [***] SETNAME app":           goredis.NewStatusCmd(ctx, "hello", 3, "AUTH", "user", "pass", "SETNAME", "app"),
+		"migrate host 6379 key 0 5000 AUTH [***]":        goredis.NewStatusCmd(ctx, "migrate", "host", 6379, "key", 0, 5000, "AUTH", "pass"),
+		"migrate host 6379 key 0 5000 AUTH2 [***] [***]": goredis.NewStatusCmd(ctx, "migrate", "host", 6379, "key", 0, 5000, "AUTH2", "user", "pass"),
+		"config set requirepass [***] maxmemory 1gb":     goredis.NewStatusCmd(ctx, "config", "set", "requirepass", "pass", "maxmemory", "1gb"),
+		"config SET masterauth [***]":                    goredis.NewStatusCmd(ctx, "config", "SET", "masterauth", "pass"),
+		"acl setuser app on [***] ~cached:* +get":        goredis.NewStatusCmd(ctx, "acl", "setuser", "app", "on", ">pass", "~cached:*", "+get"),
+		"config get requirepass":                         goredis.NewStatusCmd(ctx, "config", "get", "requirepass"),
+	} {
+		require.Equal(t, expected, hook.format(cmd))
+	}
+}
+
+func TestSetHookConcurrently(t *testing.T) {
+	defer SetHook(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for ctx.Err() == nil {
+			_ = Client().Get(ctx, "test:hook:concurrent").Err()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		SetHook(&RedisLoggerHook{})
+		SetHook(nil)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	require.NoError(t, Client().Ping(context.Background()).Err())
+
+	status := HealthCtx(context.Background())
+	require.True(t, status.Healthy)
+	require.Len(t, status.Nodes, 1)
+	require.Equal(t, mr.Addr(), status.Nodes[0].Addr)
+	require.Equal(t, RoleMaster, status.Nodes[0].Role)
+	require.Empty(t, status.Nodes[0].Error)
+	require.NotZero(t, status.Pool.TotalConns)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	status = HealthCtx(ctx)
+	require.False(t, status.Healthy)
+	require.NotEmpty(t, status.Nodes[0].Error)
+}
+
+func TestReplicaAddrs(t *testing.T) {
+	replicas := []interface{}{
+		[]interface{}{"name", "10.0.0.2:6379", "ip", "10.0.0.2", "port", "6379", "flags", "slave"},
+		[]interface{}{"name", "10.0.0.3:6380", "ip", "10.0.0.3", "port", "6380", "flags", "slave,s_down"},
+		[]interface{}{"name", "broken"},
+	}
+	require.Equal(t, []string{"10.0.0.2:6379", "10.0.0.3:6380"}, replicaAddrs(replicas))
+}
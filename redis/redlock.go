@@ -0,0 +1,291 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/transerver/commons/configs"
+	"github.com/transerver/commons/logger"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// redLockDriftFactor the clock drift factor of the ttl, see https://redis.io/topics/distlock
+	redLockDriftFactor = 0.01
+
+	// redLockDriftMin the minimum clock drift added to every lock
+	redLockDriftMin = 2 * time.Millisecond
+)
+
+// Locker is implemented by Lock and RedLock
+type Locker interface {
+	TTL() (time.Duration, error)
+	TTLCtx(ctx context.Context) (time.Duration, error)
+	Refresh(ttl time.Duration) error
+	RefreshCtx(ctx context.Context, ttl time.Duration) error
+	Release() error
+	ReleaseCtx(ctx context.Context) error
+	LoggedRelease()
+}
+
+var (
+	_ Locker = (*Lock)(nil)
+	_ Locker = (*RedLock)(nil)
+)
+
+// RedLocker obtains locks on a quorum of independent redis nodes,
+// it's the Redlock algorithm described in https://redis.io/topics/distlock
+type RedLocker struct {
+	clients []redis.UniversalClient
+	quorum  int
+}
+
+// NewRedLocker creates a RedLocker with a client for each config,
+// every config should point to an independent redis node or cluster
+func NewRedLocker(configs ...*configs.RedisConfig) *RedLocker {
+	clients := make([]redis.UniversalClient, 0, len(configs))
+	for _, config := range configs {
+		clients = append(clients, newUniversalClient(config))
+	}
+	return NewRedLockerWithClients(clients...)
+}
+
+// NewRedLockerWithClients creates a RedLocker with the existing clients
+func NewRedLockerWithClients(clients ...redis.UniversalClient) *RedLocker {
+	return &RedLocker{clients: clients, quorum: len(clients)/2 + 1}
+}
+
+// Close closes all the clients of the RedLocker
+func (r *RedLocker) Close() error {
+	var err error
+	for _, c := range r.clients {
+		if cerr := c.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// RedLock is a lock held on a quorum of redis nodes
+type RedLock struct {
+	Key    string
+	value  string
+	Locked bool
+
+	// Validity is the time the lock is considered valid after obtained,
+	// the ttl minus the time elapsed to acquire and the clock drift
+	Validity time.Duration
+
+	locker *RedLocker
+}
+
+func (r *RedLocker) Obtain(ttl time.Duration, format string, v ...interface{}) (*RedLock, error) {
+	return r.ObtainCtx(context.Background(), ttl, format, v...)
+}
+
+// ObtainCtx tries to obtain the lock on every node, the lock is locked when
+// it's acquired on the majority of nodes within the ttl minus the clock drift.
+// Otherwise, the lock is released on all nodes.
+func (r *RedLocker) ObtainCtx(ctx context.Context, ttl time.Duration, format string, v ...interface{}) (*RedLock, error) {
+	if len(v) > 0 {
+		format = fmt.Sprintf(format, v...)
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &RedLock{Key: format, value: token, locker: r}
+	start := time.Now()
+	acquired, err := r.each(func(c redis.UniversalClient) (bool, error) {
+		return c.SetNX(ctx, lock.Key, token, ttl).Result()
+	})
+
+	lock.Validity = ttl - time.Since(start) - drift(ttl)
+	if acquired >= r.quorum && lock.Validity > 0 {
+		lock.Locked = true
+		return lock, nil
+	}
+
+	lock.Validity = 0
+	_, _ = r.each(func(c redis.UniversalClient) (bool, error) {
+		return lockReleased(luaRelease.Run(ctx, c, []string{lock.Key}, token).Result())
+	})
+	if err != nil && acquired+r.failures(err) >= r.quorum {
+		logger.Errorf("redlock:obtain %+v", err)
+		return lock, err
+	}
+	return lock, nil
+}
+
+// failures returns how many nodes failed with the error
+func (r *RedLocker) failures(err error) int {
+	if e, ok := err.(*redLockError); ok {
+		return e.failures
+	}
+	return 0
+}
+
+// each runs fn on all nodes concurrently, returns the number of
+// nodes on which fn succeeded and the last error if any node failed
+func (r *RedLocker) each(fn func(c redis.UniversalClient) (bool, error)) (int, error) {
+	var (
+		wg        sync.WaitGroup
+		mutex     sync.Mutex
+		succeeded int
+		lastErr   *redLockError
+	)
+
+	for _, c := range r.clients {
+		wg.Add(1)
+		go func(c redis.UniversalClient) {
+			defer wg.Done()
+			ok, err := fn(c)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				if lastErr == nil {
+					lastErr = &redLockError{}
+				}
+				lastErr.err = err
+				lastErr.failures++
+			} else if ok {
+				succeeded++
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	if lastErr != nil {
+		return succeeded, lastErr
+	}
+	return succeeded, nil
+}
+
+type redLockError struct {
+	err      error
+	failures int
+}
+
+func (e *redLockError) Error() string {
+	return fmt.Sprintf("redlock: %d nodes failed, last error: %s", e.failures, e.err)
+}
+
+func (e *redLockError) Unwrap() error {
+	return e.err
+}
+
+func drift(ttl time.Duration) time.Duration {
+	return time.Duration(float64(ttl)*redLockDriftFactor) + redLockDriftMin
+}
+
+func lockReleased(res interface{}, err error) (bool, error) {
+	if err == redis.Nil {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	i, ok := res.(int64)
+	return ok && i == 1, nil
+}
+
+// TTL returns the remaining time-to-live which the lock still be held on the quorum.
+// Returns 0 if the lock has expired on the majority of nodes.
+func (l *RedLock) TTL() (time.Duration, error) {
+	return l.TTLCtx(context.Background())
+}
+
+func (l *RedLock) TTLCtx(ctx context.Context) (time.Duration, error) {
+	var (
+		mutex sync.Mutex
+		ttls  []time.Duration
+	)
+	_, err := l.locker.each(func(c redis.UniversalClient) (bool, error) {
+		res, err := luaPTTL.Run(ctx, c, []string{l.Key}, l.value).Result()
+		if err == redis.Nil {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		if num := res.(int64); num > 0 {
+			mutex.Lock()
+			ttls = append(ttls, time.Duration(num)*time.Millisecond)
+			mutex.Unlock()
+			return true, nil
+		}
+		return false, nil
+	})
+
+	quorum := l.locker.quorum
+	if len(ttls) < quorum {
+		if err != nil && len(ttls)+l.locker.failures(err) >= quorum {
+			return 0, err
+		}
+		return 0, nil
+	}
+
+	sort.Slice(ttls, func(i, j int) bool { return ttls[i] > ttls[j] })
+	return ttls[quorum-1], nil
+}
+
+// Refresh extends the lock with a new TTL on all nodes.
+// May return ErrNotObtained if refresh is unsuccessful on the quorum.
+func (l *RedLock) Refresh(ttl time.Duration) error {
+	return l.RefreshCtx(context.Background(), ttl)
+}
+
+func (l *RedLock) RefreshCtx(ctx context.Context, ttl time.Duration) error {
+	ttlVal := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+	start := time.Now()
+	refreshed, err := l.locker.each(func(c redis.UniversalClient) (bool, error) {
+		status, err := luaRefresh.Run(ctx, c, []string{l.Key}, l.value, ttlVal).Result()
+		return status == int64(1), err
+	})
+
+	validity := ttl - time.Since(start) - drift(ttl)
+	if refreshed >= l.locker.quorum && validity > 0 {
+		l.Validity = validity
+		return nil
+	}
+	if err != nil && refreshed+l.locker.failures(err) >= l.locker.quorum {
+		return err
+	}
+	return ErrNotObtained
+}
+
+// Release manually releases the lock on all nodes, the failed nodes
+// are ignored when the lock is released on the quorum.
+// May return ErrLockNotHeld if the lock isn't held on any node.
+func (l *RedLock) Release() error {
+	return l.ReleaseCtx(context.Background())
+}
+
+func (l *RedLock) ReleaseCtx(ctx context.Context) error {
+	released, err := l.locker.each(func(c redis.UniversalClient) (bool, error) {
+		return lockReleased(luaRelease.Run(ctx, c, []string{l.Key}, l.value).Result())
+	})
+	l.Locked = false
+	l.Validity = 0
+
+	if err != nil && released < l.locker.quorum {
+		return err
+	}
+	if released == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+func (l *RedLock) LoggedRelease() {
+	err := l.Release()
+	if err != nil {
+		logger.Errorf("%s, key: %s", err.Error(), l.Key)
+	}
+}
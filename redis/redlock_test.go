@@ -0,0 +1,76 @@
+package redis
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/transerver/commons/configs"
+	"testing"
+	"time"
+)
+
+func newRedLocker(t *testing.T, n int) (*RedLocker, []*miniredis.Miniredis) {
+	nodes := make([]*miniredis.Miniredis, n)
+	cfgs := make([]*configs.RedisConfig, n)
+	for i := range nodes {
+		nodes[i] = miniredis.RunT(t)
+		cfgs[i] = &configs.RedisConfig{Addrs: []string{nodes[i].Addr()}}
+	}
+	locker := NewRedLocker(cfgs...)
+	t.Cleanup(func() { _ = locker.Close() })
+	return locker, nodes
+}
+
+func TestRedLock(t *testing.T) {
+	locker, nodes := newRedLocker(t, 3)
+
+	lock, err := locker.Obtain(time.Second*5, "test %s", "redlock")
+	require.NoError(t, err)
+	require.True(t, lock.Locked)
+	require.Greater(t, lock.Validity, time.Duration(0))
+	for _, node := range nodes {
+		require.True(t, node.Exists(lock.Key))
+	}
+
+	other, err := locker.Obtain(time.Second*5, "test %s", "redlock")
+	require.NoError(t, err)
+	require.False(t, other.Locked)
+
+	require.NoError(t, lock.Refresh(time.Second*10))
+	ttl, err := lock.TTL()
+	require.NoError(t, err)
+	require.Equal(t, time.Second*10, ttl)
+
+	require.NoError(t, lock.Release())
+	for _, node := range nodes {
+		require.False(t, node.Exists(lock.Key))
+	}
+	require.ErrorIs(t, lock.Release(), ErrLockNotHeld)
+}
+
+func TestRedLockQuorum(t *testing.T) {
+	locker, nodes := newRedLocker(t, 3)
+
+	nodes[0].Close()
+	lock, err := locker.Obtain(time.Second*5, "test %s", "redlock:quorum")
+	require.NoError(t, err)
+	require.True(t, lock.Locked)
+	require.NoError(t, lock.Release())
+
+	_ = nodes[1].Set(lock.Key, "other")
+	lock, err = locker.Obtain(time.Second*5, "test %s", "redlock:quorum")
+	require.Error(t, err)
+	require.False(t, lock.Locked)
+	require.False(t, nodes[2].Exists(lock.Key), "lock should be released on minority nodes")
+}
+
+func TestRedLockHeldByOther(t *testing.T) {
+	locker, nodes := newRedLocker(t, 3)
+
+	_ = nodes[0].Set("test redlock:other", "other")
+	_ = nodes[1].Set("test redlock:other", "other")
+	lock, err := locker.Obtain(time.Second*5, "test %s", "redlock:other")
+	require.NoError(t, err)
+	require.False(t, lock.Locked)
+	require.False(t, nodes[2].Exists(lock.Key), "lock should be released on minority nodes")
+	require.ErrorIs(t, lock.Refresh(time.Second), ErrNotObtained)
+}
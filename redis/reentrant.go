@@ -0,0 +1,113 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/transerver/commons/logger"
+	"strconv"
+	"time"
+)
+
+var (
+	luaReentrantObtain = redis.NewScript(`if redis.call("exists", KEYS[1]) == 0 or redis.call("hexists", KEYS[1], ARGV[1]) == 1 then
+	local count = redis.call("hincrby", KEYS[1], ARGV[1], 1)
+	if tonumber(ARGV[2]) > 0 then redis.call("pexpire", KEYS[1], ARGV[2]) else redis.call("persist", KEYS[1]) end
+	return count
+end
+return 0`)
+	luaReentrantRelease = redis.NewScript(`if redis.call("hexists", KEYS[1], ARGV[1]) == 0 then return -1 end
+local count = redis.call("hincrby", KEYS[1], ARGV[1], -1)
+if count <= 0 then redis.call("del", KEYS[1]) end
+return count`)
+	luaHashRefresh = redis.NewScript(`if redis.call("hexists", KEYS[1], ARGV[1]) == 1 then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`)
+	luaHashPTTL    = redis.NewScript(`if redis.call("hexists", KEYS[1], ARGV[1]) == 1 then return redis.call("pttl", KEYS[1]) else return -3 end`)
+)
+
+// ReentrantLock is a lock which can be obtained again by the same owner,
+// the hold count of the owner is tracked in a redis hash
+type ReentrantLock struct {
+	Key    string
+	Owner  string
+	Locked bool
+
+	// Count is the hold count of the owner after obtained or released
+	Count int64
+}
+
+var _ Locker = (*ReentrantLock)(nil)
+
+func ObtainReentrant(owner string, ttl time.Duration, format string, v ...interface{}) (*ReentrantLock, error) {
+	return ObtainReentrantCtx(context.Background(), owner, ttl, format, v...)
+}
+
+// ObtainReentrantCtx obtains the lock for owner, increments the hold count
+// and resets the ttl if the lock is already held by the owner, the lock never expires if ttl <= 0
+func ObtainReentrantCtx(ctx context.Context, owner string, ttl time.Duration, format string, v ...interface{}) (*ReentrantLock, error) {
+	if len(v) > 0 {
+		format = fmt.Sprintf(format, v...)
+	}
+
+	ttlVal := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+	count, err := luaReentrantObtain.Run(ctx, Client(), []string{format}, owner, ttlVal).Int64()
+	if err != nil {
+		logger.Errorf("redislock:obtain reentrant %+v", err)
+		return nil, err
+	}
+	return &ReentrantLock{Key: format, Owner: owner, Locked: count > 0, Count: count}, nil
+}
+
+// TTL returns the remaining time-to-live. Returns 0 if the lock has expired.
+func (l *ReentrantLock) TTL() (time.Duration, error) {
+	return l.TTLCtx(context.Background())
+}
+
+func (l *ReentrantLock) TTLCtx(ctx context.Context) (time.Duration, error) {
+	return pttl(luaHashPTTL.Run(ctx, client, []string{l.Key}, l.Owner).Result())
+}
+
+// Refresh extends the lock with a new TTL.
+// May return ErrNotObtained if refresh is unsuccessful.
+func (l *ReentrantLock) Refresh(ttl time.Duration) error {
+	return l.RefreshCtx(context.Background(), ttl)
+}
+
+func (l *ReentrantLock) RefreshCtx(ctx context.Context, ttl time.Duration) error {
+	ttlVal := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+	status, err := luaHashRefresh.Run(ctx, client, []string{l.Key}, l.Owner, ttlVal).Result()
+	if err != nil {
+		return err
+	} else if status == int64(1) {
+		return nil
+	}
+	return ErrNotObtained
+}
+
+// Release decrements the hold count of the owner,
+// the lock is deleted when the count reaches zero.
+// May return ErrLockNotHeld.
+func (l *ReentrantLock) Release() error {
+	return l.ReleaseCtx(context.Background())
+}
+
+func (l *ReentrantLock) ReleaseCtx(ctx context.Context) error {
+	count, err := luaReentrantRelease.Run(ctx, client, []string{l.Key}, l.Owner).Int64()
+	if err != nil {
+		return err
+	} else if count < 0 {
+		l.Locked = false
+		l.Count = 0
+		return ErrLockNotHeld
+	}
+
+	l.Count = count
+	l.Locked = count > 0
+	return nil
+}
+
+func (l *ReentrantLock) LoggedRelease() {
+	err := l.Release()
+	if err != nil {
+		logger.Errorf("%s, key: %s", err.Error(), l.Key)
+	}
+}
@@ -0,0 +1,180 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/transerver/commons/logger"
+	"strconv"
+	"time"
+)
+
+const (
+	rwModeRead  = "read"
+	rwModeWrite = "write"
+)
+
+// luaRWPrune sets now in milliseconds by the redis time, and removes the expired readers
+// of the read lock, the key is deleted if no readers left
+const luaRWPrune = `local t = redis.call("time")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+if redis.call("hget", KEYS[1], "mode") == "read" then
+	local fields = redis.call("hgetall", KEYS[1])
+	for i = 1, #fields, 2 do
+		local expiry = tonumber(fields[i + 1])
+		if fields[i] ~= "mode" and expiry > 0 and expiry <= now then redis.call("hdel", KEYS[1], fields[i]) end
+	end
+	if redis.call("hlen", KEYS[1]) <= 1 then redis.call("del", KEYS[1]) end
+end
+`
+
+var (
+	// the field of each reader holds its expiry, 0 if it never expires, the key lives as long as the last reader
+	luaReadObtain = redis.NewScript(luaRWPrune + `local mode = redis.call("hget", KEYS[1], "mode")
+if mode == false or mode == "read" then
+	local ttl = tonumber(ARGV[2])
+	if ttl <= 0 then
+		redis.call("hset", KEYS[1], "mode", "read", ARGV[1], 0)
+		redis.call("persist", KEYS[1])
+		return 1
+	end
+	local pttl = redis.call("pttl", KEYS[1])
+	redis.call("hset", KEYS[1], "mode", "read", ARGV[1], now + ttl)
+	if mode == false or pttl >= 0 and pttl < ttl then redis.call("pexpire", KEYS[1], ttl) end
+	return 1
+end
+return 0`)
+	luaWriteObtain = redis.NewScript(luaRWPrune + `if redis.call("exists", KEYS[1]) == 0 then
+	redis.call("hset", KEYS[1], "mode", "write", ARGV[1], 1)
+	if tonumber(ARGV[2]) > 0 then redis.call("pexpire", KEYS[1], ARGV[2]) end
+	return 1
+end
+return 0`)
+	luaReadRefresh = redis.NewScript(luaRWPrune + `if redis.call("hexists", KEYS[1], ARGV[1]) == 0 then return 0 end
+redis.call("hset", KEYS[1], ARGV[1], now + tonumber(ARGV[2]))
+if redis.call("pttl", KEYS[1]) < tonumber(ARGV[2]) then redis.call("pexpire", KEYS[1], ARGV[2]) end
+return 1`)
+	luaReadPTTL = redis.NewScript(luaRWPrune + `local expiry = redis.call("hget", KEYS[1], ARGV[1])
+if not expiry then return -3 elseif tonumber(expiry) == 0 then return -1 else return tonumber(expiry) - now end`)
+	luaRWRelease = redis.NewScript(luaRWPrune + `if redis.call("hexists", KEYS[1], ARGV[1]) == 0 then return 0 end
+redis.call("hdel", KEYS[1], ARGV[1])
+if redis.call("hlen", KEYS[1]) <= 1 then redis.call("del", KEYS[1]) end
+return 1`)
+)
+
+// RWLock is a read/write lock which can be held by many readers or one writer,
+// the mode and holders are stored in a redis hash. Each reader expires on its own,
+// so a crashed reader never blocks the writers after its ttl even if the others keep refreshing
+type RWLock struct {
+	Key    string
+	value  string
+	Locked bool
+	mode   string
+}
+
+var _ Locker = (*RWLock)(nil)
+
+// ObtainRead obtains the lock for reading, it's obtained unless the lock is held by a writer.
+// The reader expires after the ttl, or never if ttl <= 0, the ttl of the key is extended if it's shorter than the ttl.
+func ObtainRead(ttl time.Duration, format string, v ...interface{}) (*RWLock, error) {
+	return ObtainReadCtx(context.Background(), ttl, format, v...)
+}
+
+func ObtainReadCtx(ctx context.Context, ttl time.Duration, format string, v ...interface{}) (*RWLock, error) {
+	return obtainRW(ctx, luaReadObtain, rwModeRead, ttl, format, v...)
+}
+
+// ObtainWrite obtains the lock for writing, it's obtained only if the lock is not held by anyone,
+// the lock never expires if ttl <= 0
+func ObtainWrite(ttl time.Duration, format string, v ...interface{}) (*RWLock, error) {
+	return ObtainWriteCtx(context.Background(), ttl, format, v...)
+}
+
+func ObtainWriteCtx(ctx context.Context, ttl time.Duration, format string, v ...interface{}) (*RWLock, error) {
+	return obtainRW(ctx, luaWriteObtain, rwModeWrite, ttl, format, v...)
+}
+
+func obtainRW(ctx context.Context, script *redis.Script, mode string, ttl time.Duration, format string, v ...interface{}) (*RWLock, error) {
+	if len(v) > 0 {
+		format = fmt.Sprintf(format, v...)
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	ttlVal := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+	status, err := script.Run(ctx, Client(), []string{format}, token, ttlVal).Result()
+	if err != nil {
+		logger.Errorf("redislock:obtain %s %+v", mode, err)
+		return nil, err
+	}
+	return &RWLock{Key: format, value: token, Locked: status == int64(1), mode: mode}, nil
+}
+
+// Writing returns whether the lock is obtained for writing
+func (l *RWLock) Writing() bool {
+	return l.mode == rwModeWrite
+}
+
+// TTL returns the remaining time-to-live. Returns 0 if the lock has expired.
+func (l *RWLock) TTL() (time.Duration, error) {
+	return l.TTLCtx(context.Background())
+}
+
+func (l *RWLock) TTLCtx(ctx context.Context) (time.Duration, error) {
+	if l.Writing() {
+		return pttl(luaHashPTTL.Run(ctx, client, []string{l.Key}, l.value).Result())
+	}
+	return pttl(luaReadPTTL.Run(ctx, client, []string{l.Key}, l.value).Result())
+}
+
+// Refresh extends the lock with a new TTL, the ttl of the key is never
+// shortened by a reader since the key is shared by all readers.
+// May return ErrNotObtained if refresh is unsuccessful.
+func (l *RWLock) Refresh(ttl time.Duration) error {
+	return l.RefreshCtx(context.Background(), ttl)
+}
+
+func (l *RWLock) RefreshCtx(ctx context.Context, ttl time.Duration) error {
+	script := luaHashRefresh
+	if !l.Writing() {
+		script = luaReadRefresh
+	}
+
+	ttlVal := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+	status, err := script.Run(ctx, client, []string{l.Key}, l.value, ttlVal).Result()
+	if err != nil {
+		return err
+	} else if status == int64(1) {
+		return nil
+	}
+	return ErrNotObtained
+}
+
+// Release manually releases the lock, the key is deleted when the last holder released.
+// May return ErrLockNotHeld.
+func (l *RWLock) Release() error {
+	return l.ReleaseCtx(context.Background())
+}
+
+func (l *RWLock) ReleaseCtx(ctx context.Context) error {
+	released, err := lockReleased(luaRWRelease.Run(ctx, client, []string{l.Key}, l.value).Result())
+	if err != nil {
+		return err
+	}
+
+	l.Locked = false
+	if !released {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+func (l *RWLock) LoggedRelease() {
+	err := l.Release()
+	if err != nil {
+		logger.Errorf("%s, key: %s", err.Error(), l.Key)
+	}
+}
@@ -0,0 +1,318 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	json "github.com/json-iterator/go"
+	"github.com/transerver/commons/logger"
+	"strings"
+	"time"
+)
+
+const (
+	// streamPayloadField the field of the stream entry which holds the JSON encoded payload
+	streamPayloadField = "payload"
+
+	defaultStreamCount         = 10
+	defaultStreamBlock         = 2 * time.Second
+	defaultStreamMinIdle       = time.Minute
+	defaultStreamClaimInterval = 30 * time.Second
+)
+
+// Producer appends the JSON encoded messages of T to a stream
+type Producer[T any] struct {
+	stream string
+	maxLen int64
+	approx bool
+}
+
+type ProducerOption func(p *producerOptions)
+
+type producerOptions struct {
+	maxLen int64
+	approx bool
+}
+
+// WithMaxLen trims the stream to maxLen entries on every add,
+// approx trims with "MAXLEN ~" which is more efficient
+func WithMaxLen(maxLen int64, approx bool) ProducerOption {
+	return func(p *producerOptions) {
+		p.maxLen = maxLen
+		p.approx = approx
+	}
+}
+
+func NewProducer[T any](stream string, opts ...ProducerOption) *Producer[T] {
+	o := &producerOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &Producer[T]{stream: stream, maxLen: o.maxLen, approx: o.approx}
+}
+
+func (p *Producer[T]) Add(v T) (string, error) {
+	return p.AddCtx(context.Background(), v)
+}
+
+// AddCtx appends the message to the stream, returns the id of the entry
+func (p *Producer[T]) AddCtx(ctx context.Context, v T) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	args := &redis.XAddArgs{
+		Stream: p.stream,
+		Values: map[string]interface{}{streamPayloadField: data},
+	}
+	args.MaxLen = p.maxLen
+	args.Approx = p.approx
+	return Client().XAdd(ctx, args).Result()
+}
+
+// Message is a stream entry delivered to a consumer
+type Message[T any] struct {
+	ID      string
+	Stream  string
+	Payload T
+
+	// RetryCount is the number of times the message has been delivered before,
+	// it's zero for a new message
+	RetryCount int64
+
+	// Logger is the logger of the consumer group, REDIS.STREAM.<stream> by default
+	Logger *logger.Logger
+}
+
+// StreamHandler handles the message, the message is acked when it returns nil,
+// otherwise it's kept pending and claimed again after the min idle time.
+type StreamHandler[T any] func(ctx context.Context, msg *Message[T]) error
+
+type ConsumerOption func(o *consumerOptions)
+
+type consumerOptions struct {
+	count         int64
+	block         time.Duration
+	minIdle       time.Duration
+	claimInterval time.Duration
+	maxRetries    int64
+	logger        *logger.Logger
+}
+
+// WithCount settings the maximum number of messages read at once
+func WithCount(count int64) ConsumerOption {
+	return func(o *consumerOptions) {
+		o.count = count
+	}
+}
+
+// WithBlock settings how long to block waiting for new messages
+func WithBlock(block time.Duration) ConsumerOption {
+	return func(o *consumerOptions) {
+		o.block = block
+	}
+}
+
+// WithClaim settings how long a pending message should be idle before claimed from
+// the dead consumer, and how often the pending messages are checked
+func WithClaim(minIdle, interval time.Duration) ConsumerOption {
+	return func(o *consumerOptions) {
+		o.minIdle = minIdle
+		o.claimInterval = interval
+	}
+}
+
+// WithMaxRetries drops the pending message after it's delivered more than maxRetries times,
+// zero means retry forever
+func WithMaxRetries(maxRetries int64) ConsumerOption {
+	return func(o *consumerOptions) {
+		o.maxRetries = maxRetries
+	}
+}
+
+func WithStreamLogger(logger *logger.Logger) ConsumerOption {
+	return func(o *consumerOptions) {
+		o.logger = logger
+	}
+}
+
+// ConsumerGroup consumes a stream as a consumer of the group,
+// the group is created automatically if it doesn't exist
+type ConsumerGroup[T any] struct {
+	stream   string
+	group    string
+	consumer string
+	handler  StreamHandler[T]
+	opts     *consumerOptions
+	Logger   *logger.Logger
+}
+
+func NewConsumerGroup[T any](stream, group, consumer string, handler StreamHandler[T], opts ...ConsumerOption) *ConsumerGroup[T] {
+	o := &consumerOptions{
+		count:         defaultStreamCount,
+		block:         defaultStreamBlock,
+		minIdle:       defaultStreamMinIdle,
+		claimInterval: defaultStreamClaimInterval,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.logger == nil {
+		o.logger = logger.NewLogger(logger.WithPrefix("REDIS.STREAM.%s", strings.ToUpper(stream)))
+	}
+
+	return &ConsumerGroup[T]{
+		stream:   stream,
+		group:    group,
+		consumer: consumer,
+		handler:  handler,
+		opts:     o,
+		Logger:   o.logger,
+	}
+}
+
+// Run creates the group if not exists, then consumes the stream until ctx is done.
+// The pending messages idle longer than the min idle time are claimed periodically.
+func (g *ConsumerGroup[T]) Run(ctx context.Context) error {
+	if err := g.createGroup(ctx); err != nil {
+		return err
+	}
+
+	var lastClaim time.Time
+	for ctx.Err() == nil {
+		if time.Since(lastClaim) >= g.opts.claimInterval {
+			if err := g.claim(ctx); err != nil {
+				g.Logger.Errorf("claim pending messages fail: %+v", err)
+			}
+			lastClaim = time.Now()
+		}
+
+		if err := g.read(ctx); err != nil {
+			g.Logger.Errorf("read messages fail: %+v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}
+	return nil
+}
+
+func (g *ConsumerGroup[T]) createGroup(ctx context.Context) error {
+	err := Client().XGroupCreateMkStream(ctx, g.stream, g.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+func (g *ConsumerGroup[T]) read(ctx context.Context) error {
+	streams, err := Client().XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    g.group,
+		Consumer: g.consumer,
+		Streams:  []string{g.stream, ">"},
+		Count:    g.opts.count,
+		Block:    g.opts.block,
+	}).Result()
+	if err == redis.Nil {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, stream := range streams {
+		for _, m := range stream.Messages {
+			g.handle(ctx, m, 0)
+		}
+	}
+	return nil
+}
+
+// claim claims the pending messages of the dead consumers which are idle longer than min idle
+func (g *ConsumerGroup[T]) claim(ctx context.Context) error {
+	pending, err := Client().XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: g.stream,
+		Group:  g.group,
+		Start:  "-",
+		End:    "+",
+		Count:  g.opts.count,
+	}).Result()
+	if err == redis.Nil {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	retries := make(map[string]int64, len(pending))
+	ids := make([]string, 0, len(pending))
+	for _, p := range pending {
+		if p.Idle < g.opts.minIdle {
+			continue
+		}
+		if g.opts.maxRetries > 0 && p.RetryCount > g.opts.maxRetries {
+			g.Logger.Errorf("drop message [%s] of consumer [%s] after %d retries", p.ID, p.Consumer, p.RetryCount)
+			g.ack(ctx, p.ID)
+			continue
+		}
+		retries[p.ID] = p.RetryCount
+		ids = append(ids, p.ID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	messages, err := Client().XClaim(ctx, &redis.XClaimArgs{
+		Stream:   g.stream,
+		Group:    g.group,
+		Consumer: g.consumer,
+		MinIdle:  g.opts.minIdle,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range messages {
+		g.handle(ctx, m, retries[m.ID])
+	}
+	return nil
+}
+
+func (g *ConsumerGroup[T]) handle(ctx context.Context, m redis.XMessage, retryCount int64) {
+	msg := &Message[T]{ID: m.ID, Stream: g.stream, RetryCount: retryCount, Logger: g.Logger}
+	if err := g.decode(m, &msg.Payload); err != nil {
+		g.Logger.Errorf("drop message [%s], decode fail: %+v", m.ID, err)
+		g.ack(ctx, m.ID)
+		return
+	}
+
+	if err := g.call(ctx, msg); err != nil {
+		g.Logger.Warnf("handle message [%s] fail: %+v", m.ID, err)
+		return
+	}
+	g.ack(ctx, m.ID)
+}
+
+func (g *ConsumerGroup[T]) call(ctx context.Context, msg *Message[T]) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return g.handler(ctx, msg)
+}
+
+func (g *ConsumerGroup[T]) decode(m redis.XMessage, v *T) error {
+	payload, ok := m.Values[streamPayloadField].(string)
+	if !ok {
+		return fmt.Errorf("field %q not found", streamPayloadField)
+	}
+	return json.Unmarshal([]byte(payload), v)
+}
+
+func (g *ConsumerGroup[T]) ack(ctx context.Context, id string) {
+	if err := Client().XAck(ctx, g.stream, g.group, id).Err(); err != nil {
+		g.Logger.Errorf("ack message [%s] fail: %+v", id, err)
+	}
+}
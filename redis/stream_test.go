@@ -0,0 +1,61 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+type event struct {
+	Name string `json:"name"`
+}
+
+func TestStream(t *testing.T) {
+	producer := NewProducer[event]("test:stream", WithMaxLen(100, false))
+	for _, name := range []string{"a", "b", "fail"} {
+		_, err := producer.Add(event{Name: name})
+		require.NoError(t, err)
+	}
+
+	var (
+		mutex    sync.Mutex
+		received []string
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*300)
+	defer cancel()
+	first := NewConsumerGroup[event]("test:stream", "group", "first", func(ctx context.Context, msg *Message[event]) error {
+		if msg.Payload.Name == "fail" {
+			return errors.New("handle fail")
+		}
+		mutex.Lock()
+		received = append(received, msg.Payload.Name)
+		mutex.Unlock()
+		return nil
+	}, WithBlock(time.Millisecond*50))
+	require.NoError(t, first.Run(ctx))
+	require.Equal(t, []string{"a", "b"}, received)
+
+	pending, err := Client().XPending(context.Background(), "test:stream", "group").Result()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), pending.Count)
+
+	var claimed *Message[event]
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*300)
+	defer cancel()
+	second := NewConsumerGroup[event]("test:stream", "group", "second", func(ctx context.Context, msg *Message[event]) error {
+		claimed = msg
+		return nil
+	}, WithBlock(time.Millisecond*50), WithClaim(time.Millisecond*100, time.Millisecond*50))
+	require.NoError(t, second.Run(ctx))
+	require.NotNil(t, claimed)
+	require.Equal(t, "fail", claimed.Payload.Name)
+	require.Equal(t, int64(1), claimed.RetryCount)
+	require.Same(t, second.Logger, claimed.Logger)
+
+	pending, err = Client().XPending(context.Background(), "test:stream", "group").Result()
+	require.NoError(t, err)
+	require.Zero(t, pending.Count)
+}
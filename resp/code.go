@@ -10,5 +10,7 @@ const (
 const (
 	CodeBaseErr Code = iota + 500 // Basic error
 	CodeParamErr
-	CodeNotLogin // You are not login
+	CodeNotLogin        // You are not login
+	CodeTooManyRequests // Too many requests, rate limited
+	CodeConflict        // Conflict with the state of the resource, e.g. a duplicated request
 )
@@ -12,22 +12,24 @@ func _() {
 	_ = x[CodeBaseErr-500]
 	_ = x[CodeParamErr-501]
 	_ = x[CodeNotLogin-502]
+	_ = x[CodeTooManyRequests-503]
+	_ = x[CodeConflict-504]
 }
 
 const (
 	_Code_name_0 = "Success"
-	_Code_name_1 = "BaseErrParamErrNotLogin"
+	_Code_name_1 = "BaseErrParamErrNotLoginTooManyRequestsConflict"
 )
 
 var (
-	_Code_index_1 = [...]uint8{0, 7, 15, 23}
+	_Code_index_1 = [...]uint8{0, 7, 15, 23, 38, 46}
 )
 
 func (i Code) String() string {
 	switch {
 	case i == 200:
 		return _Code_name_0
-	case 500 <= i && i <= 502:
+	case 500 <= i && i <= 504:
 		i -= 500
 		return _Code_name_1[_Code_index_1[i]:_Code_index_1[i+1]]
 	default:
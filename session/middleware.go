@@ -0,0 +1,94 @@
+package session
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/transerver/commons/logger"
+	"github.com/transerver/commons/resp"
+	"net/http"
+	"strings"
+)
+
+const (
+	// contextKey the key of the session in gin.Context
+	contextKey = "commons/session"
+
+	bearerPrefix = "Bearer "
+)
+
+// Middleware returns a gin middleware which loads the session by the id in the cookie,
+// or the Authorization header with the Bearer scheme. The requests without a session
+// of a logged-in user are aborted with http.StatusUnauthorized and resp.CodeNotLogin.
+func (s *Store) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sess, err := s.GetCtx(c.Request.Context(), s.sessionID(c))
+		if err != nil && err != ErrSessionNotFound {
+			logger.Errorf("session: load the session fail: %+v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, resp.Msg(resp.CodeBaseErr, resp.CodeBaseErr.String()))
+			return
+		}
+		if sess == nil || len(sess.UserID) == 0 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, resp.Msg(resp.CodeNotLogin, resp.CodeNotLogin.String()))
+			return
+		}
+
+		c.Set(contextKey, sess)
+		c.Next()
+	}
+}
+
+// FromContext returns the session loaded by the Middleware, nil if not found
+func FromContext(c *gin.Context) *Session {
+	if v, ok := c.Get(contextKey); ok {
+		return v.(*Session)
+	}
+	return nil
+}
+
+// Login creates a session for the user and writes the id to the cookie,
+// the session of the request if any is revoked to prevent session fixation
+func (s *Store) Login(c *gin.Context, userId string, values map[string]interface{}) (*Session, error) {
+	ctx := c.Request.Context()
+	if old, err := s.GetCtx(ctx, s.sessionID(c)); err == nil {
+		_ = s.RevokeCtx(ctx, old)
+	}
+
+	sess, err := s.CreateCtx(ctx, userId, values)
+	if err != nil {
+		return nil, err
+	}
+	s.SetCookie(c, sess)
+	c.Set(contextKey, sess)
+	return sess, nil
+}
+
+// Logout revokes the session of the request and clears the cookie
+func (s *Store) Logout(c *gin.Context) error {
+	sess := FromContext(c)
+	if sess == nil {
+		var err error
+		if sess, err = s.GetCtx(c.Request.Context(), s.sessionID(c)); err == ErrSessionNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	c.SetCookie(s.cookieName, "", -1, "/", s.cookieDomain, s.cookieSecure, true)
+	return s.RevokeCtx(c.Request.Context(), sess)
+}
+
+// SetCookie writes the session id to the cookie, it should be called after Store.Rotate.
+// It's a browser session cookie, the sliding expiry is managed by the store.
+func (s *Store) SetCookie(c *gin.Context, sess *Session) {
+	c.SetCookie(s.cookieName, sess.ID, 0, "/", s.cookieDomain, s.cookieSecure, true)
+}
+
+func (s *Store) sessionID(c *gin.Context) string {
+	if id, err := c.Cookie(s.cookieName); err == nil && len(id) > 0 {
+		return id
+	}
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, bearerPrefix) {
+		return strings.TrimPrefix(auth, bearerPrefix)
+	}
+	return ""
+}
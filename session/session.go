@@ -0,0 +1,353 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	goredis "github.com/go-redis/redis/v8"
+	json "github.com/json-iterator/go"
+	"github.com/transerver/commons/redis"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPrefix     = "session:"
+	defaultTTL        = 30 * time.Minute
+	defaultCookieName = "session_id"
+
+	// idLen the number of random bytes of the session id
+	idLen = 32
+)
+
+var (
+	ErrSessionNotFound = errors.New("session: not found or expired")
+
+	// luaLoad returns the session and slides its expiry
+	luaLoad = goredis.NewScript(`local v = redis.call("get", KEYS[1])
+if v then redis.call("pexpire", KEYS[1], ARGV[1]) end
+return v`)
+
+	// luaExtend extends the ttl of the key, it never shortens the ttl
+	luaExtend = goredis.NewScript(`local ttl = redis.call("pttl", KEYS[1])
+if ttl == -1 or (ttl >= 0 and ttl < tonumber(ARGV[1])) then redis.call("pexpire", KEYS[1], ARGV[1]) end
+return ttl`)
+)
+
+// Session is the data of a login session, the Values are stored as JSON
+type Session struct {
+	ID        string                 `json:"-"`
+	UserID    string                 `json:"userId"`
+	Values    map[string]interface{} `json:"values"`
+	CreatedAt time.Time              `json:"createdAt"`
+
+	// indexed the user whose index contains the session
+	indexed string
+}
+
+func (s *Session) Get(key string) interface{} {
+	return s.Values[key]
+}
+
+// Set settings the value of the session, call Store.Save to persist it
+func (s *Session) Set(key string, value interface{}) {
+	if s.Values == nil {
+		s.Values = make(map[string]interface{})
+	}
+	s.Values[key] = value
+}
+
+// Store stores the sessions in redis, a session expires if it's not accessed within the ttl.
+// The session ids of a user are indexed by a set, so all of them can be revoked at once.
+type Store struct {
+	prefix       string
+	ttl          time.Duration
+	cookieName   string
+	cookieDomain string
+	cookieSecure bool
+}
+
+type Option func(s *Store)
+
+// WithPrefix settings the prefix of the session keys, the default is "session:"
+func WithPrefix(prefix string) Option {
+	return func(s *Store) {
+		if len(prefix) > 0 && !strings.HasSuffix(prefix, ":") {
+			prefix += ":"
+		}
+		s.prefix = prefix
+	}
+}
+
+// WithTTL settings the idle timeout of the sessions, the expiry slides on every access
+func WithTTL(ttl time.Duration) Option {
+	return func(s *Store) {
+		s.ttl = ttl
+	}
+}
+
+// WithCookie settings the cookie which carries the session id
+func WithCookie(name, domain string, secure bool) Option {
+	return func(s *Store) {
+		s.cookieName = name
+		s.cookieDomain = domain
+		s.cookieSecure = secure
+	}
+}
+
+func NewStore(opts ...Option) *Store {
+	s := &Store{prefix: defaultPrefix, ttl: defaultTTL, cookieName: defaultCookieName}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *Store) key(id string) string {
+	return s.prefix + id
+}
+
+func (s *Store) userKey(userId string) string {
+	return s.prefix + "user:" + userId
+}
+
+// newID returns an opaque session id generated by the CSPRNG
+func newID() (string, error) {
+	b := make([]byte, idLen)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (s *Store) Create(userId string, values map[string]interface{}) (*Session, error) {
+	return s.CreateCtx(context.Background(), userId, values)
+}
+
+// CreateCtx creates a session for the user, the userId can be empty for an anonymous session
+func (s *Store) CreateCtx(ctx context.Context, userId string, values map[string]interface{}) (*Session, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	sess := &Session{ID: id, UserID: userId, Values: values, CreatedAt: time.Now(), indexed: userId}
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return nil, err
+	}
+	if err := redis.Client().Set(ctx, s.key(id), data, s.ttl).Err(); err != nil {
+		return nil, err
+	}
+	return sess, s.index(ctx, userId, id)
+}
+
+func (s *Store) Get(id string) (*Session, error) {
+	return s.GetCtx(context.Background(), id)
+}
+
+// GetCtx returns the session and slides its expiry, returns ErrSessionNotFound if it's expired or revoked
+func (s *Store) GetCtx(ctx context.Context, id string) (*Session, error) {
+	if len(id) == 0 {
+		return nil, ErrSessionNotFound
+	}
+
+	data, err := luaLoad.Run(ctx, redis.Client(), []string{s.key(id)}, s.ttl.Milliseconds()).Text()
+	if err == goredis.Nil {
+		return nil, ErrSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	sess := &Session{ID: id}
+	if err := json.Unmarshal([]byte(data), sess); err != nil {
+		return nil, err
+	}
+	sess.indexed = sess.UserID
+	if len(sess.UserID) > 0 {
+		err = luaExtend.Run(ctx, redis.Client(), []string{s.userKey(sess.UserID)}, s.ttl.Milliseconds()).Err()
+	}
+	return sess, err
+}
+
+func (s *Store) Save(sess *Session) error {
+	return s.SaveCtx(context.Background(), sess)
+}
+
+// SaveCtx persists the values of the session and slides its expiry,
+// returns ErrSessionNotFound if it's expired or revoked
+func (s *Store) SaveCtx(ctx context.Context, sess *Session) error {
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+
+	ok, err := redis.Client().SetXX(ctx, s.key(sess.ID), data, s.ttl).Result()
+	if err != nil {
+		return err
+	} else if !ok {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *Store) Rotate(sess *Session) error {
+	return s.RotateCtx(context.Background(), sess)
+}
+
+// RotateCtx moves the session to a new id, it should be called on privilege change,
+// e.g. login or the roles of the user changed, to prevent session fixation.
+// The UserID of the session may be changed before rotation.
+func (s *Store) RotateCtx(ctx context.Context, sess *Session) error {
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+
+	c := redis.Client()
+	if err := c.Set(ctx, s.key(id), data, s.ttl).Err(); err != nil {
+		return err
+	}
+
+	// the old session may be revoked concurrently, the new one must not outlive it
+	deleted, err := c.Del(ctx, s.key(sess.ID)).Result()
+	if err == nil && deleted == 0 {
+		err = ErrSessionNotFound
+	}
+	if err != nil {
+		_ = c.Del(ctx, s.key(id)).Err()
+		return err
+	}
+
+	old := sess.ID
+	sess.ID = id
+	if err := s.unindex(ctx, sess.indexed, old); err != nil {
+		return err
+	}
+	sess.indexed = sess.UserID
+	return s.index(ctx, sess.UserID, id)
+}
+
+func (s *Store) Revoke(sess *Session) error {
+	return s.RevokeCtx(context.Background(), sess)
+}
+
+// RevokeCtx deletes the session
+func (s *Store) RevokeCtx(ctx context.Context, sess *Session) error {
+	if err := redis.Client().Del(ctx, s.key(sess.ID)).Err(); err != nil {
+		return err
+	}
+	return s.unindex(ctx, sess.indexed, sess.ID)
+}
+
+func (s *Store) RevokeUser(userId string) (int64, error) {
+	return s.RevokeUserCtx(context.Background(), userId)
+}
+
+// RevokeUserCtx deletes all the sessions of the user, returns the number of sessions revoked.
+// Only the ids read are removed from the index, so a session created meanwhile is still indexed
+// and revoked by the next RevokeUser
+func (s *Store) RevokeUserCtx(ctx context.Context, userId string) (int64, error) {
+	c := redis.Client()
+	ids, err := c.SMembers(ctx, s.userKey(userId)).Result()
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	// the keys are deleted one by one, they may be in different slots in cluster mode
+	cmds := make([]*goredis.IntCmd, len(ids))
+	members := make([]interface{}, len(ids))
+	_, err = c.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.Del(ctx, s.key(id))
+			members[i] = id
+		}
+		pipe.SRem(ctx, s.userKey(userId), members...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var revoked int64
+	for _, cmd := range cmds {
+		revoked += cmd.Val()
+	}
+	return revoked, nil
+}
+
+func (s *Store) Sessions(userId string) ([]*Session, error) {
+	return s.SessionsCtx(context.Background(), userId)
+}
+
+// SessionsCtx returns the alive sessions of the user, the expired ones are removed from the index
+func (s *Store) SessionsCtx(ctx context.Context, userId string) ([]*Session, error) {
+	c := redis.Client()
+	ids, err := c.SMembers(ctx, s.userKey(userId)).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	cmds := make([]*goredis.StringCmd, len(ids))
+	_, err = c.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.Get(ctx, s.key(id))
+		}
+		return nil
+	})
+	if err != nil && err != goredis.Nil {
+		return nil, err
+	}
+
+	var (
+		sessions []*Session
+		expired  []interface{}
+	)
+	for i, cmd := range cmds {
+		data, err := cmd.Bytes()
+		if err == goredis.Nil {
+			expired = append(expired, ids[i])
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		sess := &Session{ID: ids[i]}
+		if err := json.Unmarshal(data, sess); err != nil {
+			return nil, err
+		}
+		sess.indexed = sess.UserID
+		sessions = append(sessions, sess)
+	}
+
+	if len(expired) > 0 {
+		err = c.SRem(ctx, s.userKey(userId), expired...).Err()
+	}
+	return sessions, err
+}
+
+func (s *Store) index(ctx context.Context, userId, id string) error {
+	if len(userId) == 0 {
+		return nil
+	}
+
+	key := s.userKey(userId)
+	c := redis.Client()
+	if err := c.SAdd(ctx, key, id).Err(); err != nil {
+		return err
+	}
+	return luaExtend.Run(ctx, c, []string{key}, s.ttl.Milliseconds()).Err()
+}
+
+func (s *Store) unindex(ctx context.Context, userId, id string) error {
+	if len(userId) == 0 {
+		return nil
+	}
+	return redis.Client().SRem(ctx, s.userKey(userId), id).Err()
+}
@@ -0,0 +1,124 @@
+package session
+
+import (
+	"context"
+	"github.com/Charliego93/go-i18n"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"github.com/transerver/commons/internal/redistest"
+	"github.com/transerver/commons/redis"
+	"golang.org/x/text/language"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var mr *miniredis.Miniredis
+
+func TestMain(m *testing.M) {
+	mr = redistest.Start(redis.SetConfig)
+	i18n.Localize(language.English, i18n.NewLoaderWithFS(fstest.MapFS{
+		"en.json": {Data: []byte(`{"NotLogin": "You are not login"}`)},
+	}))
+	redistest.Run(m, mr)
+}
+
+func TestSlidingExpiry(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(WithPrefix("test:session"), WithTTL(time.Minute))
+	sess, err := store.CreateCtx(ctx, "1", map[string]interface{}{"role": "user"})
+	require.NoError(t, err)
+	require.True(t, mr.Exists("test:session:"+sess.ID))
+
+	mr.FastForward(time.Second * 40)
+	loaded, err := store.GetCtx(ctx, sess.ID)
+	require.NoError(t, err)
+	require.Equal(t, "1", loaded.UserID)
+	require.Equal(t, "user", loaded.Get("role"))
+
+	mr.FastForward(time.Second * 40)
+	_, err = store.GetCtx(ctx, sess.ID)
+	require.NoError(t, err, "the expiry should slide on access")
+
+	mr.FastForward(time.Minute)
+	_, err = store.GetCtx(ctx, sess.ID)
+	require.ErrorIs(t, err, ErrSessionNotFound)
+	require.ErrorIs(t, store.SaveCtx(ctx, loaded), ErrSessionNotFound)
+}
+
+func TestRotateAndRevoke(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(WithPrefix("test:rotate"))
+	anonymous, err := store.CreateCtx(ctx, "", nil)
+	require.NoError(t, err)
+
+	old := anonymous.ID
+	anonymous.UserID = "2"
+	anonymous.Set("role", "admin")
+	require.NoError(t, store.RotateCtx(ctx, anonymous))
+	require.NotEqual(t, old, anonymous.ID)
+	_, err = store.GetCtx(ctx, old)
+	require.ErrorIs(t, err, ErrSessionNotFound)
+
+	other, err := store.CreateCtx(ctx, "2", nil)
+	require.NoError(t, err)
+	sessions, err := store.SessionsCtx(ctx, "2")
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+
+	revoked, err := store.RevokeUserCtx(ctx, "2")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), revoked)
+	for _, id := range []string{anonymous.ID, other.ID} {
+		_, err = store.GetCtx(ctx, id)
+		require.ErrorIs(t, err, ErrSessionNotFound)
+	}
+	require.ErrorIs(t, store.RotateCtx(ctx, other), ErrSessionNotFound)
+	require.False(t, mr.Exists(store.userKey("2")))
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewStore(WithPrefix("test:middleware"))
+	router := gin.New()
+	router.POST("/login", func(c *gin.Context) {
+		if _, err := store.Login(c, "3", nil); err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}
+	})
+	router.GET("/me", store.Middleware(), func(c *gin.Context) {
+		c.String(http.StatusOK, FromContext(c).UserID)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/login", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	require.True(t, cookies[0].HttpOnly)
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.AddCookie(cookies[0])
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "3", w.Body.String())
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+cookies[0].Value)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	_, err := store.RevokeUserCtx(context.Background(), "3")
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.AddCookie(cookies[0])
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Contains(t, w.Body.String(), `"code":502`)
+}